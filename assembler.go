@@ -0,0 +1,553 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AsmError is an error found while assembling, located by file, line and column
+type AsmError struct {
+	File string
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *AsmError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Col, e.Msg)
+}
+
+// AsmErrors collects every error found in a source file
+type AsmErrors []*AsmError
+
+func (e AsmErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Program is an assembled LC-3 program
+type Program struct {
+	Origin  uint16
+	Words   []uint16
+	Symbols map[string]uint16
+}
+
+// WriteObj writes the program in the big-endian, origin-prefixed format read by Load
+func (p *Program) WriteObj(w io.Writer) error {
+	b := make([]byte, 2*(len(p.Words)+1))
+	binary.BigEndian.PutUint16(b, p.Origin)
+	for i, word := range p.Words {
+		binary.BigEndian.PutUint16(b[2*(i+1):], word)
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+// WriteSym writes the labels of the program as a symbol file, see ParseSymbols
+func (p *Program) WriteSym(w io.Writer) error {
+	var names []string
+	for name := range p.Symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	syms := NewSymbolTable()
+	for _, name := range names {
+		syms.Add(name, p.Symbols[name])
+	}
+	return syms.WriteSymbols(w)
+}
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokNumber
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	text string // identifier as written or decoded string literal
+	val  int    // value of a number
+	col  int
+}
+
+// asmLine is a single parsed source line
+type asmLine struct {
+	num      int
+	label    *token
+	op       *token
+	operands []token
+	addr     uint16
+}
+
+// trapAliases maps the trap mnemonics to their vectors
+var trapAliases = map[string]uint16{
+	"GETC":  TrapGETC,
+	"OUT":   TrapOUT,
+	"PUTS":  TrapPUTS,
+	"IN":    TrapIN,
+	"PUTSP": TrapPUTSP,
+	"HALT":  TrapHALT,
+}
+
+var mnemonics = map[string]bool{
+	"ADD": true, "AND": true, "NOT": true, "JMP": true, "RET": true,
+	"JSR": true, "JSRR": true, "LD": true, "LDI": true, "LDR": true,
+	"LEA": true, "ST": true, "STI": true, "STR": true, "TRAP": true, "RTI": true,
+}
+
+// isOpcode reports whether name (upper case) is an instruction, trap alias or directive
+func isOpcode(name string) bool {
+	if mnemonics[name] || strings.HasPrefix(name, ".") {
+		return true
+	}
+	if _, ok := trapAliases[name]; ok {
+		return true
+	}
+	_, ok := branchFlags(name)
+	return ok
+}
+
+// branchFlags returns the nzp bits of a BR mnemonic such as BRnz
+func branchFlags(name string) (uint16, bool) {
+	if !strings.HasPrefix(name, "BR") {
+		return 0, false
+	}
+	suffix := name[2:]
+	if suffix == "" {
+		return CondNEG | CondZRO | CondPOS, true
+	}
+
+	var flags uint16
+	last := -1
+	for _, c := range suffix {
+		i := strings.IndexRune("NZP", c)
+		if i <= last {
+			return 0, false
+		}
+		last = i
+		flags |= CondNEG >> uint(i)
+	}
+	return flags, true
+}
+
+type assembler struct {
+	file    string
+	errs    AsmErrors
+	symbols map[string]uint16
+}
+
+func (as *assembler) errorf(line, col int, format string, args ...interface{}) {
+	as.errs = append(as.errs, &AsmError{File: as.file, Line: line, Col: col, Msg: fmt.Sprintf(format, args...)})
+}
+
+// Assemble translates LC-3 assembly source into a program. file is only used in error messages.
+func Assemble(file string, src []byte) (*Program, error) {
+	as := &assembler{file: file, symbols: map[string]uint16{}}
+
+	var lines []*asmLine
+	for i, text := range strings.Split(string(src), "\n") {
+		if l := as.parseLine(i+1, text); l != nil {
+			lines = append(lines, l)
+		}
+	}
+	if len(as.errs) > 0 {
+		return nil, as.errs
+	}
+
+	prog := as.layout(lines)
+	if len(as.errs) > 0 {
+		return nil, as.errs
+	}
+
+	for _, l := range lines {
+		if l.op != nil {
+			prog.Words = append(prog.Words, as.encode(l)...)
+		}
+	}
+	if len(as.errs) > 0 {
+		return nil, as.errs
+	}
+
+	prog.Symbols = as.symbols
+	return prog, nil
+}
+
+// layout assigns addresses to all lines and collects the labels
+func (as *assembler) layout(lines []*asmLine) *Program {
+	prog := &Program{}
+	var addr int
+	started, ended := false, false
+	lastLine := 0
+
+	for _, l := range lines {
+		lastLine = l.num
+		if ended {
+			as.errorf(l.num, 1, "statement after .END")
+			break
+		}
+
+		name := ""
+		if l.op != nil {
+			name = strings.ToUpper(l.op.text)
+		}
+
+		if !started {
+			if name != ".ORIG" {
+				as.errorf(l.num, 1, "expected .ORIG before the first statement")
+				return prog
+			}
+			if l.label != nil {
+				as.errorf(l.num, l.label.col, "label not allowed on .ORIG")
+			}
+			if v, ok := as.number(l, 0, 0, 0xFFFF); ok {
+				prog.Origin = uint16(v)
+				addr = v
+			}
+			started = true
+			continue
+		}
+
+		l.addr = uint16(addr)
+
+		if l.label != nil {
+			key := strings.ToUpper(l.label.text)
+			if _, dup := as.symbols[key]; dup {
+				as.errorf(l.num, l.label.col, "duplicate label %s", l.label.text)
+			}
+			as.symbols[key] = l.addr
+		}
+
+		switch name {
+		case "":
+		case ".ORIG":
+			as.errorf(l.num, l.op.col, "only one .ORIG block per file is supported")
+		case ".END":
+			ended = true
+		case ".BLKW":
+			if v, ok := as.number(l, 0, 1, 0xFFFF); ok {
+				addr += v
+			}
+		case ".STRINGZ":
+			if len(l.operands) != 1 || l.operands[0].kind != tokString {
+				as.errorf(l.num, l.op.col, ".STRINGZ expects a string")
+				continue
+			}
+			addr += len(l.operands[0].text) + 1
+		default:
+			addr++
+		}
+
+		if addr > 0x10000 {
+			as.errorf(l.num, 1, "program does not fit in memory")
+			return prog
+		}
+	}
+
+	if !started {
+		as.errorf(lastLine+1, 1, "missing .ORIG")
+	} else if !ended {
+		as.errorf(lastLine, 1, "missing .END")
+	}
+	return prog
+}
+
+// encode returns the words a line assembles to
+func (as *assembler) encode(l *asmLine) []uint16 {
+	name := strings.ToUpper(l.op.text)
+
+	if vect, ok := trapAliases[name]; ok {
+		as.arity(l, 0)
+		return []uint16{OpTRAP<<12 | vect}
+	}
+	if flags, ok := branchFlags(name); ok {
+		as.arity(l, 1)
+		return []uint16{OpBR<<12 | flags<<9 | as.pcOffset(l, 0, 9)}
+	}
+
+	switch name {
+	case ".ORIG", ".END":
+		return nil
+	case ".FILL":
+		as.arity(l, 1)
+		if len(l.operands) > 0 && l.operands[0].kind == tokIdent {
+			addr, _ := as.symbol(l, 0)
+			return []uint16{addr}
+		}
+		v, _ := as.number(l, 0, -0x8000, 0xFFFF)
+		return []uint16{uint16(v)}
+	case ".BLKW":
+		v, _ := as.number(l, 0, 1, 0xFFFF)
+		return make([]uint16, v)
+	case ".STRINGZ":
+		s := l.operands[0].text
+		words := make([]uint16, len(s)+1)
+		for i := 0; i < len(s); i++ {
+			words[i] = uint16(s[i])
+		}
+		return words
+	case "ADD", "AND":
+		op := OpADD
+		if name == "AND" {
+			op = OpAND
+		}
+		as.arity(l, 3)
+		instr := op<<12 | as.register(l, 0)<<9 | as.register(l, 1)<<6
+		if len(l.operands) > 2 && l.operands[2].kind == tokNumber {
+			v, _ := as.number(l, 2, -16, 15)
+			return []uint16{instr | 1<<5 | uint16(v)&0x1F}
+		}
+		return []uint16{instr | as.register(l, 2)}
+	case "NOT":
+		as.arity(l, 2)
+		return []uint16{OpNOT<<12 | as.register(l, 0)<<9 | as.register(l, 1)<<6 | 0x3F}
+	case "JMP":
+		as.arity(l, 1)
+		return []uint16{OpJMP<<12 | as.register(l, 0)<<6}
+	case "RET":
+		as.arity(l, 0)
+		return []uint16{OpJMP<<12 | 7<<6}
+	case "JSR":
+		as.arity(l, 1)
+		return []uint16{OpJSR<<12 | 1<<11 | as.pcOffset(l, 0, 11)}
+	case "JSRR":
+		as.arity(l, 1)
+		return []uint16{OpJSR<<12 | as.register(l, 0)<<6}
+	case "LD", "LDI", "LEA", "ST", "STI":
+		op := map[string]uint16{"LD": OpLD, "LDI": OpLDI, "LEA": OpLEA, "ST": OpST, "STI": OpSTI}[name]
+		as.arity(l, 2)
+		return []uint16{op<<12 | as.register(l, 0)<<9 | as.pcOffset(l, 1, 9)}
+	case "LDR", "STR":
+		op := OpLDR
+		if name == "STR" {
+			op = OpSTR
+		}
+		as.arity(l, 3)
+		v, _ := as.number(l, 2, -32, 31)
+		return []uint16{op<<12 | as.register(l, 0)<<9 | as.register(l, 1)<<6 | uint16(v)&0x3F}
+	case "TRAP":
+		as.arity(l, 1)
+		v, _ := as.number(l, 0, 0, 0xFF)
+		return []uint16{OpTRAP<<12 | uint16(v)}
+	case "RTI":
+		as.arity(l, 0)
+		return []uint16{OpRTI << 12}
+	}
+
+	as.errorf(l.num, l.op.col, "unknown directive %s", l.op.text)
+	return nil
+}
+
+func (as *assembler) arity(l *asmLine, n int) {
+	if len(l.operands) > n {
+		as.errorf(l.num, l.operands[n].col, "too many operands for %s", l.op.text)
+	}
+}
+
+// operand returns the i-th operand, reporting an error if it is missing
+func (as *assembler) operand(l *asmLine, i int) (token, bool) {
+	if i >= len(l.operands) {
+		as.errorf(l.num, l.op.col, "missing operand %d for %s", i+1, l.op.text)
+		return token{}, false
+	}
+	return l.operands[i], true
+}
+
+func (as *assembler) register(l *asmLine, i int) uint16 {
+	t, ok := as.operand(l, i)
+	if !ok {
+		return 0
+	}
+	if r, ok := registerIndex(t.text); ok && t.kind == tokIdent {
+		return uint16(r)
+	}
+	as.errorf(l.num, t.col, "expected register R0-R7, got %s", t.text)
+	return 0
+}
+
+func (as *assembler) number(l *asmLine, i, min, max int) (int, bool) {
+	t, ok := as.operand(l, i)
+	if !ok {
+		return 0, false
+	}
+	if t.kind != tokNumber {
+		as.errorf(l.num, t.col, "expected a number, got %s", t.text)
+		return 0, false
+	}
+	if t.val < min || t.val > max {
+		as.errorf(l.num, t.col, "value %d out of range [%d, %d]", t.val, min, max)
+		return 0, false
+	}
+	return t.val, true
+}
+
+func (as *assembler) symbol(l *asmLine, i int) (uint16, bool) {
+	t, ok := as.operand(l, i)
+	if !ok {
+		return 0, false
+	}
+	addr, ok := as.symbols[strings.ToUpper(t.text)]
+	if !ok {
+		as.errorf(l.num, t.col, "undefined label %s", t.text)
+	}
+	return addr, ok
+}
+
+// pcOffset encodes a label or literal offset as a PC-relative offset of the given width
+func (as *assembler) pcOffset(l *asmLine, i int, bits uint) uint16 {
+	t, ok := as.operand(l, i)
+	if !ok {
+		return 0
+	}
+
+	min, max := -(1 << (bits - 1)), 1<<(bits-1)-1
+	var offset int
+	if t.kind == tokNumber {
+		offset = t.val
+	} else {
+		addr, ok := as.symbol(l, i)
+		if !ok {
+			return 0
+		}
+		offset = int(addr) - int(l.addr) - 1
+	}
+
+	if offset < min || offset > max {
+		as.errorf(l.num, t.col, "offset %d to %s does not fit in %d bits", offset, t.text, bits)
+		return 0
+	}
+	return uint16(offset) & (0xFFFF >> (16 - bits))
+}
+
+// parseLine splits a source line into label, opcode and operands
+func (as *assembler) parseLine(num int, text string) *asmLine {
+	toks, ok := as.tokenize(num, text)
+	if !ok || len(toks) == 0 {
+		return nil
+	}
+
+	l := &asmLine{num: num}
+	if toks[0].kind == tokIdent && !isOpcode(strings.ToUpper(toks[0].text)) {
+		label := toks[0]
+		label.text = strings.TrimSuffix(label.text, ":")
+		l.label = &label
+		toks = toks[1:]
+	}
+	if len(toks) == 0 {
+		return l
+	}
+
+	if toks[0].kind != tokIdent || !isOpcode(strings.ToUpper(toks[0].text)) {
+		as.errorf(num, toks[0].col, "unknown instruction %s", toks[0].text)
+		return nil
+	}
+	l.op = &toks[0]
+	l.operands = toks[1:]
+	return l
+}
+
+// tokenize splits a line into tokens, dropping commas and comments
+func (as *assembler) tokenize(num int, text string) ([]token, bool) {
+	var toks []token
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ';':
+			return toks, true
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			i++
+		case c == '"':
+			s, n, err := unquote(text[i:])
+			if err != "" {
+				as.errorf(num, i+1+n, "%s", err)
+				return nil, false
+			}
+			toks = append(toks, token{kind: tokString, text: s, col: i + 1})
+			i += n
+		default:
+			start := i
+			for i < len(text) && !strings.ContainsRune(" \t\r,;\"", rune(text[i])) {
+				i++
+			}
+			word := text[start:i]
+			if v, ok := parseNumber(word); ok {
+				toks = append(toks, token{kind: tokNumber, text: word, val: v, col: start + 1})
+			} else if word[0] == '#' || word[0] == '-' || (word[0] >= '0' && word[0] <= '9') {
+				as.errorf(num, start+1, "invalid number %s", word)
+				return nil, false
+			} else {
+				toks = append(toks, token{kind: tokIdent, text: word, col: start + 1})
+			}
+		}
+	}
+	return toks, true
+}
+
+// parseNumber parses #decimal, decimal, xHEX and 0xHEX literals
+func parseNumber(s string) (int, bool) {
+	if s == "" {
+		return 0, false
+	}
+
+	base := 10
+	switch {
+	case s[0] == '#':
+		s = s[1:]
+	case s[0] == 'x' || s[0] == 'X':
+		s, base = s[1:], 16
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		s, base = s[2:], 16
+	}
+
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+	if s == "" || strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		return 0, false
+	}
+
+	v, err := strconv.ParseInt(s, base, 32)
+	if err != nil {
+		return 0, false
+	}
+	if neg {
+		v = -v
+	}
+	return int(v), true
+}
+
+// unquote decodes the string literal at the start of s and returns it with the number of bytes consumed.
+// On failure it returns a message and the offset of the offending byte.
+func unquote(s string) (string, int, string) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), i + 1, ""
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", i, "unterminated string"
+			}
+			esc, ok := map[byte]byte{'n': '\n', 't': '\t', 'r': '\r', '0': 0, 'e': 0x1B, '"': '"', '\\': '\\'}[s[i]]
+			if !ok {
+				return "", i, fmt.Sprintf("unknown escape sequence \\%c", s[i])
+			}
+			b.WriteByte(esc)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", len(s) - 1, "unterminated string"
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNumber(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		s string
+
+		expected   int
+		expectedOK bool
+	}{
+		{s: "12", expected: 12, expectedOK: true},
+		{s: "#-5", expected: -5, expectedOK: true},
+		{s: "x3000", expected: 0x3000, expectedOK: true},
+		{s: "0xff", expected: 0xFF, expectedOK: true},
+		{s: ""},
+		{s: "#"},
+		{s: "x"},
+		{s: "x-"},
+		{s: "R1"},
+	}
+
+	for _, testData := range tests {
+		v, ok := parseNumber(testData.s)
+		assert.Equal(testData.expectedOK, ok, "Number %q", testData.s)
+		assert.Equal(testData.expected, v, "Number %q", testData.s)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	assert := assert.New(t)
+
+	src := `
+; test program
+        .ORIG x3000
+START   ADD R1, R2, R3
+        ADD R1, R2, #-1
+        AND R0, R0, x0
+        NOT R4, R5
+        BRnz START
+        BR END
+        JMP R3
+        RET
+        JSR SUB
+        JSRR R2
+        LD R0, DATA
+        LDI R1, DATA
+        LDR R2, R6, #-2
+        LEA R3, MSG
+        ST R0, DATA
+        STI R1, DATA
+        STR R2, R6, #5
+        TRAP x25
+        RTI
+SUB     GETC
+        OUT
+        PUTS
+        IN
+        PUTSP
+END     HALT
+DATA    .FILL xBEEF
+        .FILL START
+        .BLKW 2
+MSG     .STRINGZ "Hi\n"
+        .END
+`
+
+	prog, err := Assemble("test.asm", []byte(src))
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(uint16(0x3000), prog.Origin)
+	assert.Equal([]uint16{
+		0x1283,         // ADD R1, R2, R3
+		0x12BF,         // ADD R1, R2, #-1
+		0x5020,         // AND R0, R0, #0
+		0x997F,         // NOT R4, R5
+		0x0DFB,         // BRnz START
+		0x0E12,         // BR END
+		0xC0C0,         // JMP R3
+		0xC1C0,         // RET
+		0x480A,         // JSR SUB
+		0x4080,         // JSRR R2
+		0x200E,         // LD R0, DATA
+		0xA20D,         // LDI R1, DATA
+		0x65BE,         // LDR R2, R6, #-2
+		0xE60F,         // LEA R3, MSG
+		0x300A,         // ST R0, DATA
+		0xB209,         // STI R1, DATA
+		0x7585,         // STR R2, R6, #5
+		0xF025,         // TRAP x25
+		0x8000,         // RTI
+		0xF020,         // GETC
+		0xF021,         // OUT
+		0xF022,         // PUTS
+		0xF023,         // IN
+		0xF024,         // PUTSP
+		0xF025,         // HALT
+		0xBEEF,         // DATA
+		0x3000,         // .FILL START
+		0x0000, 0x0000, // .BLKW 2
+		'H', 'i', '\n', 0,
+	}, prog.Words)
+	assert.Equal(uint16(0x3013), prog.Symbols["SUB"])
+}
+
+func TestAssembleErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		description string
+		src         string
+
+		expected string
+	}{
+		{
+			description: "Missing .ORIG",
+			src:         "ADD R0, R0, R0\n.END",
+
+			expected: "a.asm:1:1: expected .ORIG before the first statement",
+		},
+		{
+			description: "Immediate out of range",
+			src:         ".ORIG x3000\n  ADD R0, R0, #16\n.END",
+
+			expected: "a.asm:2:15: value 16 out of range [-16, 15]",
+		},
+		{
+			description: "Bad register",
+			src:         ".ORIG x3000\nNOT R0, R8\n.END",
+
+			expected: "a.asm:2:9: expected register R0-R7, got R8",
+		},
+		{
+			description: "Undefined label",
+			src:         ".ORIG x3000\n\tBRz NOWHERE\n.END",
+
+			expected: "a.asm:2:6: undefined label NOWHERE",
+		},
+		{
+			description: "Unknown instruction",
+			src:         ".ORIG x3000\nLOOP FOO R1\n.END",
+
+			expected: "a.asm:2:6: unknown instruction FOO",
+		},
+		{
+			description: "Unterminated string",
+			src:         ".ORIG x3000\n.STRINGZ \"abc\n.END",
+
+			expected: "a.asm:2:13: unterminated string",
+		},
+		{
+			description: "Missing .END",
+			src:         ".ORIG x3000\nHALT",
+
+			expected: "a.asm:2:1: missing .END",
+		},
+	}
+
+	for _, testData := range tests {
+		_, err := Assemble("a.asm", []byte(testData.src))
+		if assert.Error(err, testData.description) {
+			assert.Equal(testData.expected, err.Error(), "Should be equal for %s", testData.description)
+		}
+	}
+}
+
+func TestAssembleLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	prog, err := Assemble("a.asm", []byte(".ORIG x4000\nAND R0, R0, #0\nHALT\n.END\n"))
+	if !assert.NoError(err) {
+		return
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(prog.WriteObj(&buf))
+
+	path := filepath.Join(t.TempDir(), "a.obj")
+	assert.NoError(ioutil.WriteFile(path, buf.Bytes(), 0644))
+
+	var memory [65536]uint16
+	segments, err := Load(&memory, path)
+	assert.NoError(err)
+	assert.Equal([]Segment{{Origin: 0x4000, Length: 2}}, segments)
+	assert.Equal(uint16(0x5020), memory[0x4000])
+	assert.Equal(uint16(0xF025), memory[0x4001])
+}
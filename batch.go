@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// Errors a batch run stops the machine with
+var (
+	ErrStepLimit = errors.New("instruction limit exceeded")
+	ErrTimeLimit = errors.New("time limit exceeded")
+)
+
+// Batch runs a machine without a terminal: scripted input is typed on the keyboard
+// and the run is stopped when the program exceeds its limits. The EOF policy of the machine
+// applies once the program waits for more input.
+type Batch struct {
+	ALU *ALU
+
+	// Input holds the characters typed for the program, one whenever the keyboard is empty
+	Input []byte
+
+	MaxSteps int           // instructions executed at most, no limit if 0
+	Timeout  time.Duration // time the run may take, no limit if 0
+
+	Steps int // instructions executed so far
+}
+
+// NewBatch returns a batch run of the given machine typing input
+func NewBatch(a *ALU, input []byte) *Batch {
+	b := &Batch{
+		ALU:   a,
+		Input: input,
+	}
+	a.keyWait = b.typeKey
+	return b
+}
+
+// Run executes instructions until the machine stops and returns the error it stopped with.
+// Exceeding a limit stops the machine with ErrStepLimit or ErrTimeLimit.
+func (b *Batch) Run() error {
+	a := b.ALU
+	var deadline time.Time
+	if b.Timeout > 0 {
+		deadline = time.Now().Add(b.Timeout)
+	}
+
+	for a.Running {
+		if a.Peek(KBSR)&0x8000 == 0 {
+			b.typeKey()
+		}
+
+		switch {
+		case b.MaxSteps > 0 && b.Steps >= b.MaxSteps:
+			b.stop(ErrStepLimit)
+		case b.Timeout > 0 && b.Steps%1024 == 0 && time.Now().After(deadline):
+			b.stop(ErrTimeLimit)
+		default:
+			a.EmulateInstruction()
+			b.Steps++
+		}
+	}
+	return a.Err
+}
+
+// typeKey delivers the next character of Input to the keyboard, or ends the input without any left
+func (b *Batch) typeKey() bool {
+	if len(b.Input) == 0 {
+		b.ALU.endInput()
+		return false
+	}
+	b.ALU.pressKey(uint16(b.Input[0]))
+	b.Input = b.Input[1:]
+	return true
+}
+
+func (b *Batch) stop(err error) {
+	b.ALU.Running = false
+	b.ALU.Err = err
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const batchEchoProgram = `
+        .ORIG x3000
+LOOP    GETC
+        OUT
+        ADD R1, R0, #-10
+        BRnp LOOP
+        HALT
+        .END
+`
+
+func TestBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		src      string
+		traps    []string
+		input    string
+		eof      EOFPolicy
+		sentinel uint16
+
+		expectedErr    error
+		expectedOutput string
+	}{
+		{
+			src:            batchEchoProgram,
+			input:          "hi\n",
+			expectedOutput: "hi\n",
+		},
+		{
+			src:            batchEchoProgram,
+			input:          "hi",
+			expectedErr:    ErrOutOfInput,
+			expectedOutput: "hi",
+		},
+		{
+			src:            batchEchoProgram,
+			traps:          []string{"native"},
+			input:          "hi",
+			expectedErr:    ErrOutOfInput,
+			expectedOutput: "hi",
+		},
+		{
+			src:            batchEchoProgram,
+			input:          "hi",
+			eof:            EOFHalt,
+			expectedOutput: "hi",
+		},
+		{
+			src:            batchEchoProgram,
+			input:          "hi",
+			eof:            EOFSentinel,
+			sentinel:       '\n',
+			expectedOutput: "hi\n",
+		},
+		{
+			src:            batchEchoProgram,
+			traps:          []string{"native"},
+			input:          "hi",
+			eof:            EOFSentinel,
+			sentinel:       '\n',
+			expectedOutput: "hi\n",
+		},
+		{
+			// GETS runs out of input in the middle of the line
+			src: `
+        .ORIG x3000
+        LEA R0, BUF
+        AND R1, R1, #0
+        ADD R1, R1, #4
+        TRAP x31
+        HALT
+BUF     .BLKW 4
+        .END
+`,
+			traps:          []string{"native", "extra"},
+			input:          "ab",
+			expectedErr:    ErrOutOfInput,
+			expectedOutput: "ab",
+		},
+	}
+
+	for _, testData := range tests {
+		a := assembleALU(t, testData.src)
+		var out bytes.Buffer
+		a.Out = &out
+		a.EOF, a.Sentinel = testData.eof, testData.sentinel
+		assert.NoError(a.EnableTraps(testData.traps...))
+
+		b := NewBatch(a, []byte(testData.input))
+		assert.Equal(testData.expectedErr, b.Run(), "Input %q", testData.input)
+		assert.False(a.Running)
+		assert.Equal(testData.expectedOutput, out.String(), "Input %q with traps %v", testData.input, testData.traps)
+	}
+}
+
+func TestBatchLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	src := `
+        .ORIG x3000
+LOOP    BRnzp LOOP
+        .END
+`
+	b := NewBatch(assembleALU(t, src), nil)
+	b.MaxSteps = 100
+	assert.Equal(ErrStepLimit, b.Run())
+	assert.Equal(100, b.Steps)
+	assert.Equal(1, b.ALU.ExitCode())
+
+	b = NewBatch(assembleALU(t, src), nil)
+	b.Timeout = time.Nanosecond
+	assert.Equal(ErrTimeLimit, b.Run())
+}
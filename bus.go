@@ -0,0 +1,121 @@
+package main
+
+// Device handles the accesses to the addresses it claims on the bus.
+// A nil callback leaves the access to plain memory.
+type Device struct {
+	Read  func(addr uint16) uint16 // value read by the program, may have side effects
+	Write func(addr, val uint16)   // value written by the program
+	Peek  func(addr uint16) uint16 // value shown to debuggers without side effects, Read is used if nil
+	Tick  func()                   // called before every instruction
+
+	// Interrupt reports a pending interrupt request, checked before every instruction
+	Interrupt func() (vector, priority uint16, ok bool)
+}
+
+// eventQueueSize is the number of events posted to a machine before Post blocks
+const eventQueueSize = 64
+
+// busMapping is an address range claimed by a device
+type busMapping struct {
+	lo, hi uint16
+	dev    *Device
+}
+
+// Map claims the addresses from lo to hi inclusively for a device.
+// Later mappings take precedence over earlier ones.
+func (a *ALU) Map(lo, hi uint16, dev *Device) {
+	a.devices = append([]busMapping{{lo, hi, dev}}, a.devices...)
+
+	if dev.Tick == nil && dev.Interrupt == nil {
+		return
+	}
+	for _, d := range a.polled {
+		if d == dev {
+			return
+		}
+	}
+	a.polled = append(a.polled, dev)
+}
+
+// Post hands an event to the CPU, which runs it before the next instruction or while a trap
+// waits for a key. Unlike the other methods of the machine it may be called from any goroutine.
+func (a *ALU) Post(event func()) {
+	a.events <- event
+}
+
+// drainEvents runs the events posted since the last instruction
+func (a *ALU) drainEvents() {
+	for {
+		select {
+		case event := <-a.events:
+			event()
+		default:
+			return
+		}
+	}
+}
+
+// tick advances the devices by one instruction
+func (a *ALU) tick() {
+	for _, dev := range a.polled {
+		if dev.Tick != nil {
+			dev.Tick()
+		}
+	}
+}
+
+// pendingInterrupt returns the interrupt request with the highest priority level
+// above the current one, if there is any
+func (a *ALU) pendingInterrupt() (uint16, uint16, bool) {
+	var vector, priority uint16
+	found := false
+	for _, dev := range a.polled {
+		if dev.Interrupt == nil {
+			continue
+		}
+		if v, p, ok := dev.Interrupt(); ok && p > a.Priority && (!found || p > priority) {
+			vector, priority, found = v, p, true
+		}
+	}
+	return vector, priority, found
+}
+
+// device returns the device claiming addr, or nil if it is plain memory
+func (a *ALU) device(addr uint16) *Device {
+	for _, m := range a.devices {
+		if addr >= m.lo && addr <= m.hi {
+			return m.dev
+		}
+	}
+	return nil
+}
+
+// Read reads a word as the program does
+func (a *ALU) Read(addr uint16) uint16 {
+	if dev := a.device(addr); dev != nil && dev.Read != nil {
+		return dev.Read(addr)
+	}
+	return a.Memory[addr]
+}
+
+// Write writes a word as the program does
+func (a *ALU) Write(addr, val uint16) {
+	if dev := a.device(addr); dev != nil && dev.Write != nil {
+		dev.Write(addr, val)
+		return
+	}
+	a.Memory[addr] = val
+}
+
+// Peek reads a word without triggering device side effects
+func (a *ALU) Peek(addr uint16) uint16 {
+	if dev := a.device(addr); dev != nil {
+		if dev.Peek != nil {
+			return dev.Peek(addr)
+		}
+		if dev.Read != nil {
+			return dev.Read(addr)
+		}
+	}
+	return a.Memory[addr]
+}
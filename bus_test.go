@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusDevice(t *testing.T) {
+	assert := assert.New(t)
+
+	var written []uint16
+	reads := 0
+	a := ALU{PCReg: PCStart}
+	a.Map(0x4000, 0x4001, &Device{
+		Read: func(addr uint16) uint16 {
+			reads++
+			return addr + 1
+		},
+		Write: func(addr, val uint16) {
+			written = append(written, addr, val)
+		},
+	})
+
+	a.Reg[1] = 0x4000
+	a.Reg[2] = 0x1234
+	a.handleLDR(buildInstr("000" + "001" + "000001"))
+	a.handleSTR(buildInstr("010" + "001" + "000000"))
+
+	assert.Equal(uint16(0x4002), a.Reg[0])
+	assert.Equal([]uint16{0x4000, 0x1234}, written)
+	assert.Equal(uint16(0), a.Memory[0x4000], "Device writes should not reach memory")
+
+	assert.Equal(uint16(0x4001), a.Peek(0x4000))
+	assert.Equal(2, reads)
+
+	a.Memory[0x4002] = 0x7777
+	assert.Equal(uint16(0x7777), a.Read(0x4002))
+}
+
+func TestBusMapPrecedence(t *testing.T) {
+	assert := assert.New(t)
+
+	a := ALU{}
+	a.Map(0x0000, 0xFFFF, &Device{Read: func(uint16) uint16 { return 1 }})
+	a.Map(0x3000, 0x3000, &Device{Read: func(uint16) uint16 { return 2 }})
+
+	assert.Equal(uint16(1), a.Read(0x2FFF))
+	assert.Equal(uint16(2), a.Read(0x3000))
+}
+
+func TestKeyboardDevice(t *testing.T) {
+	assert := assert.New(t)
+
+	a := NewALU()
+	assert.Equal(uint16(0), a.Read(KBSR))
+
+	a.pressKey('x')
+	assert.Equal(uint16(0x8000), a.Read(KBSR))
+	assert.Equal(uint16('x'), a.Peek(KBDR))
+	assert.Equal(uint16(0x8000), a.Read(KBSR), "Peeking KBDR should keep the ready bit")
+
+	assert.Equal(uint16('x'), a.Read(KBDR))
+	assert.Equal(uint16(0), a.Read(KBSR), "Reading KBDR should clear the ready bit")
+
+	a.Write(KBSR, 0xFFFF)
+	assert.Equal(uint16(0x4000), a.Read(KBSR), "Only the interrupt enable bit is writable")
+}
+
+func TestProcessInput(t *testing.T) {
+	assert := assert.New(t)
+
+	a := NewALU()
+	a.In = strings.NewReader("k")
+	processInput(a)
+	a.drainEvents()
+
+	assert.Equal(uint16(0x8000), a.Read(KBSR))
+	assert.Equal(uint16('k'), a.Read(KBDR))
+}
+
+func TestTypeAhead(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		typeAhead int
+		overflow  OverflowPolicy
+
+		expected string
+	}{
+		{typeAhead: DefaultTypeAhead, expected: "abcd"},
+		{typeAhead: 2, overflow: DropNewest, expected: "abc"},
+		{typeAhead: 2, overflow: DropOldest, expected: "bcd"},
+		{typeAhead: 0, overflow: DropNewest, expected: "a"},
+		{typeAhead: 0, overflow: DropOldest, expected: "d"},
+	}
+
+	for _, testData := range tests {
+		a := NewALU()
+		a.TypeAhead, a.TypeAheadOverflow = testData.typeAhead, testData.overflow
+		for _, c := range "abcd" {
+			a.pressKey(uint16(c))
+		}
+
+		var read []byte
+		for a.Read(KBSR)&0x8000 != 0 {
+			read = append(read, byte(a.Read(KBDR)))
+		}
+		assert.Equal(testData.expected, string(read), "Typeahead %d, overflow %v", testData.typeAhead, testData.overflow)
+	}
+}
+
+func TestRedirectedInput(t *testing.T) {
+	assert := assert.New(t)
+
+	src := `
+        .ORIG x3000
+        AND R0, R0, #0
+LOOP    GETC
+        OUT
+        BRnzp LOOP
+        .END
+`
+	input := strings.Repeat("The quick brown fox jumps over the lazy dog.\n", 50)
+
+	for _, typeAhead := range []int{DefaultTypeAhead, 4, 0} {
+		a := assembleALU(t, src)
+		var out bytes.Buffer
+		a.Out = &out
+		a.EOF = EOFHalt
+		a.TypeAhead = typeAhead
+		a.In = strings.NewReader(input)
+
+		go processInput(a)
+		for a.Running {
+			a.EmulateInstruction()
+		}
+		assert.NoError(a.Err)
+		assert.Equal(input, out.String(), "Redirected input should not overflow a typeahead buffer of %d keys", typeAhead)
+	}
+}
+
+func TestConcurrentInput(t *testing.T) {
+	assert := assert.New(t)
+
+	src := `
+        .ORIG x3000
+LOOP    GETC
+        OUT
+        ADD R1, R0, #-10
+        BRnp LOOP
+        HALT
+        .END
+`
+	tests := []struct {
+		traps []string
+		input string
+
+		expectedErr    error
+		expectedOutput string
+	}{
+		{input: "hello\n", expectedOutput: "hello\n"},
+		{traps: []string{"native"}, input: "hello\n", expectedOutput: "hello\n"},
+		{input: "hello", expectedErr: ErrOutOfInput, expectedOutput: "hello"},
+		{traps: []string{"native"}, input: "hello", expectedErr: ErrOutOfInput, expectedOutput: "hello"},
+	}
+
+	for _, testData := range tests {
+		a := assembleALU(t, src)
+		var out bytes.Buffer
+		a.Out = &out
+		assert.NoError(a.EnableTraps(testData.traps...))
+		a.In = strings.NewReader(testData.input)
+
+		go processInput(a)
+		for a.Running {
+			a.EmulateInstruction()
+		}
+		assert.Equal(testData.expectedErr, a.Err, "Input %q with traps %v", testData.input, testData.traps)
+		assert.Equal(testData.expectedOutput, out.String(), "Input %q with traps %v", testData.input, testData.traps)
+	}
+}
+
+func TestEndOfInput(t *testing.T) {
+	assert := assert.New(t)
+
+	a := NewALU()
+	a.In = strings.NewReader("k")
+	processInput(a)
+	a.drainEvents()
+	assert.Equal(uint16('k'), a.Read(KBDR))
+	assert.True(a.Running)
+
+	assert.Equal(uint16(0), a.Read(KBSR))
+	assert.False(a.Running, "Polling KBSR after the end of the input should stop the machine")
+	assert.Equal(ErrOutOfInput, a.Err)
+
+	a = NewALU()
+	a.EOF, a.Sentinel = EOFSentinel, 0x04
+	a.endInput()
+	for i := 0; i < 2; i++ {
+		assert.Equal(uint16(0x8000), a.Read(KBSR))
+		assert.Equal(uint16(0x04), a.Read(KBDR))
+	}
+	assert.True(a.Running)
+}
+
+func TestDisplayDevice(t *testing.T) {
+	assert := assert.New(t)
+
+	a := assembleALU(t, `
+        .ORIG x3000
+        LEA R1, MSG
+LOOP    LDR R0, R1, #0
+        BRz DONE
+POLL    LDI R2, DSRA
+        BRzp POLL
+        STI R0, DDRA
+        ADD R1, R1, #1
+        BR LOOP
+DONE    HALT
+DSRA    .FILL xFE04
+DDRA    .FILL xFE06
+MSG     .STRINGZ "Hi"
+        .END
+`)
+	var out bytes.Buffer
+	a.Out = &out
+
+	for a.Running {
+		a.EmulateInstruction()
+	}
+	assert.Equal("Hi", out.String())
+
+	assert.Equal(uint16(0x8000), a.Read(DSR))
+	a.Write(DDR, '!')
+	assert.Equal(uint16(0), a.Read(DSR), "DSR should not be ready right after a write")
+	a.tick()
+	assert.Equal(uint16(0x8000), a.Read(DSR))
+	assert.Equal("Hi!", out.String())
+}
+
+func TestMCRDevice(t *testing.T) {
+	assert := assert.New(t)
+
+	a := assembleALU(t, `
+        .ORIG x3000
+        LDI R0, MCRA
+        LD R1, MASK
+        AND R0, R0, R1
+        STI R0, MCRA
+        ADD R2, R2, #1
+MCRA    .FILL xFFFE
+MASK    .FILL x7FFF
+        .END
+`)
+	assert.Equal(uint16(0x8000), a.Read(MCR))
+
+	steps := 0
+	for a.Running {
+		a.EmulateInstruction()
+		steps++
+	}
+
+	assert.Equal(4, steps)
+	assert.Equal(uint16(0), a.Reg[2], "No instruction should run once the clock is disabled")
+	assert.Equal(uint16(0), a.Read(MCR))
+}
+
+func TestKeyboardInterrupt(t *testing.T) {
+	assert := assert.New(t)
+
+	a := assembleALU(t, `
+        .ORIG x3000
+        LD R6, STACK
+        LD R1, IE
+        STI R1, KBSRA
+LOOP    ADD R2, R2, #1
+        ADD R3, R3, #0
+        BRz LOOP
+        HALT
+HANDLER LDI R3, KBDRA
+        RTI
+STACK   .FILL x2FF0
+IE      .FILL x4000
+KBSRA   .FILL xFE00
+KBDRA   .FILL xFE02
+        .END
+`)
+	a.Memory[IntVectorTable+IntKeyboard] = 0x3007
+
+	for i := 0; i < 10; i++ {
+		a.EmulateInstruction()
+	}
+	assert.Equal(uint16(0), a.Reg[3])
+
+	a.pressKey('k')
+	a.EmulateInstruction()
+	assert.Equal(uint16(0x3007), a.PCReg, "The handler should have been entered")
+	assert.Equal(uint16(KeyboardPriority), a.Priority)
+	assert.Equal(uint16(0x2FEE), a.Reg[6])
+
+	for i := 0; a.Running && i < 1000; i++ {
+		a.EmulateInstruction()
+	}
+	assert.False(a.Running)
+	assert.Equal(uint16('k'), a.Reg[3])
+	assert.Equal(uint16(0), a.Priority)
+	assert.Equal(uint16(0x2FF0-2), a.Reg[6], "HALT stops in its service routine, with PSR and PC on the stack")
+	assert.Equal(uint16(0x4000), a.Read(KBSR))
+}
+
+func TestKeyboardInterruptDefaultStack(t *testing.T) {
+	assert := assert.New(t)
+
+	// a program that does not set up a stack uses the supervisor stack of a new machine
+	a := assembleALU(t, `
+        .ORIG x3000
+        LD R1, IE
+        STI R1, KBSRA
+LOOP    ADD R3, R3, #0
+        BRz LOOP
+        HALT
+HANDLER LDI R3, KBDRA
+        RTI
+IE      .FILL x4000
+KBSRA   .FILL xFE00
+KBDRA   .FILL xFE02
+        .END
+`)
+	a.Memory[IntVectorTable+IntKeyboard] = 0x3005
+
+	for i := 0; i < 10; i++ {
+		a.EmulateInstruction()
+	}
+	a.pressKey('k')
+	a.EmulateInstruction()
+	assert.Equal(uint16(0x3005), a.PCReg, "The handler should have been entered")
+	assert.Equal(uint16(SSPStart-2), a.Reg[6])
+
+	for i := 0; a.Running && i < 1000; i++ {
+		a.EmulateInstruction()
+	}
+	assert.False(a.Running)
+	assert.NoError(a.Err)
+	assert.Equal(uint16('k'), a.Reg[3])
+	assert.Equal(uint16(SSPStart-2), a.Reg[6], "HALT stops in its service routine, with PSR and PC on the stack")
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+// addressFlag is a flag holding an LC-3 address written as x3000, #12288 or 12288
+type addressFlag struct {
+	value uint16
+	set   bool
+}
+
+func (f *addressFlag) String() string {
+	return fmt.Sprintf("x%04X", f.value)
+}
+
+func (f *addressFlag) Set(s string) error {
+	if s == "" {
+		return fmt.Errorf("empty address")
+	}
+	v, ok := parseNumber(s)
+	if !ok || v < 0 || v > 0xFFFF {
+		return fmt.Errorf("invalid address %q", s)
+	}
+	f.value, f.set = uint16(v), true
+	return nil
+}
+
+// trapsFlag is a comma separated list of trap bundles, see TrapBundles
+type trapsFlag []string
+
+func (f *trapsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *trapsFlag) Set(s string) error {
+	*f = append(*f, strings.Split(s, ",")...)
+	return nil
+}
+
+// registersFlag presets registers, written as R1=x10. It may be repeated or comma separated.
+type registersFlag map[int]uint16
+
+func (f registersFlag) String() string {
+	var regs []string
+	for r := 0; r < 8; r++ {
+		if v, ok := f[r]; ok {
+			regs = append(regs, fmt.Sprintf("R%d=x%04X", r, v))
+		}
+	}
+	return strings.Join(regs, ",")
+}
+
+func (f registersFlag) Set(s string) error {
+	for _, assignment := range strings.Split(s, ",") {
+		parts := strings.SplitN(assignment, "=", 2)
+		r, ok := registerIndex(parts[0])
+		if !ok || len(parts) != 2 {
+			return fmt.Errorf("invalid register assignment %q, expected Rn=VALUE", assignment)
+		}
+		v, ok := parseNumber(parts[1])
+		if !ok || v < -0x8000 || v > 0xFFFF {
+			return fmt.Errorf("invalid value %q", parts[1])
+		}
+		f[r] = uint16(v)
+	}
+	return nil
+}
+
+// eofFlag is an EOF policy written as error, halt or the sentinel character, like x04
+type eofFlag struct {
+	policy   EOFPolicy
+	sentinel uint16
+}
+
+func (f *eofFlag) String() string {
+	switch f.policy {
+	case EOFHalt:
+		return "halt"
+	case EOFSentinel:
+		return fmt.Sprintf("x%04X", f.sentinel)
+	}
+	return "error"
+}
+
+func (f *eofFlag) Set(s string) error {
+	switch s {
+	case "error":
+		f.policy = EOFError
+	case "halt":
+		f.policy = EOFHalt
+	default:
+		v, ok := parseNumber(s)
+		if !ok || v < -0x8000 || v > 0xFFFF {
+			return fmt.Errorf("invalid end of input policy %q, expected error, halt or a character like x04", s)
+		}
+		f.policy, f.sentinel = EOFSentinel, uint16(v)
+	}
+	return nil
+}
+
+// overflowFlag is an OverflowPolicy written as newest or oldest
+type overflowFlag OverflowPolicy
+
+func (f *overflowFlag) String() string {
+	if OverflowPolicy(*f) == DropOldest {
+		return "oldest"
+	}
+	return "newest"
+}
+
+func (f *overflowFlag) Set(s string) error {
+	switch s {
+	case "newest":
+		*f = overflowFlag(DropNewest)
+	case "oldest":
+		*f = overflowFlag(DropOldest)
+	default:
+		return fmt.Errorf("invalid overflow policy %q, expected newest or oldest", s)
+	}
+	return nil
+}
+
+// machineFlags are the flags configuring the machine of the commands running programs
+type machineFlags struct {
+	traps   trapsFlag
+	sandbox string
+	pc      addressFlag
+	regs    registersFlag
+	trace   bool
+	banner  bool
+	eof     eofFlag
+
+	typeAhead int
+	overflow  overflowFlag
+}
+
+func (m *machineFlags) register(fs *flag.FlagSet) {
+	m.regs = registersFlag{}
+	fs.Var(&m.traps, "traps", "comma separated trap bundles to run in Go: native, extra")
+	fs.StringVar(&m.sandbox, "sandbox", "", "enable the semihosting traps with access to the files in this directory")
+	fs.Var(&m.pc, "pc", "start address (default: origin of the program)")
+	fs.Var(m.regs, "reg", "preset a register, e.g. R1=x10")
+	fs.BoolVar(&m.trace, "trace", false, "write every instruction executed to standard error")
+	fs.BoolVar(&m.banner, "banner", false, "print a message when HALT stops the machine")
+	fs.IntVar(&m.typeAhead, "typeahead", DefaultTypeAhead, "keys buffered while the program has not read the previous one")
+	fs.Var(&m.overflow, "overflow", "key dropped when typing on a terminal fills the typeahead buffer: newest or oldest")
+	fs.Var(&m.eof, "eof", "when the program waits for input after its end: error, halt, or type a character like x04 or xFFFF")
+}
+
+// start sets PC to origin unless it was overridden and presets the registers
+func (m *machineFlags) start(a *ALU, origin uint16) {
+	a.PCReg = origin
+	if m.pc.set {
+		a.PCReg = m.pc.value
+	}
+	for r, v := range m.regs {
+		a.Reg[r] = v
+	}
+}
+
+// newALU returns a machine with the traps selected by the flags
+func (m *machineFlags) newALU() (*ALU, error) {
+	if m.typeAhead < 0 {
+		return nil, fmt.Errorf("-typeahead %d: the buffer size cannot be negative", m.typeAhead)
+	}
+	a := NewALU()
+	if err := a.EnableTraps(m.traps...); err != nil {
+		return nil, err
+	}
+	if m.sandbox != "" {
+		if fi, err := os.Stat(m.sandbox); err != nil {
+			return nil, err
+		} else if !fi.IsDir() {
+			return nil, fmt.Errorf("sandbox %s is not a directory", m.sandbox)
+		}
+		NewSemihost(m.sandbox).Enable(a)
+	}
+	if m.trace {
+		a.Trace = os.Stderr
+	}
+	a.ShowHaltBanner(m.banner)
+	a.EOF, a.Sentinel = m.eof.policy, m.eof.sentinel
+	a.TypeAhead, a.TypeAheadOverflow = m.typeAhead, OverflowPolicy(m.overflow)
+	return a, nil
+}
+
+// loadProgram loads object files in order together with their symbol files
+func loadProgram(a *ALU, paths []string) ([][]Segment, error) {
+	segments, err := LoadFiles(&a.Memory, paths)
+	if err != nil {
+		return nil, err
+	}
+	if a.Symbols, err = LoadSymbols(paths...); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+// exitStatus is returned by a command to exit with a status other than 1 without a message
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+// loadEntry loads object files in order on a new machine and starts it at the origin
+// of the entry-th file, counting from 1
+func (m *machineFlags) loadEntry(paths []string, entry int) (*ALU, error) {
+	if entry < 1 || entry > len(paths) {
+		return nil, fmt.Errorf("-entry %d: there are %d object files", entry, len(paths))
+	}
+
+	a, err := m.newALU()
+	if err != nil {
+		return nil, err
+	}
+	segments, err := loadProgram(a, paths)
+	if err != nil {
+		return nil, err
+	}
+	m.start(a, segments[entry-1][0].Origin)
+	return a, nil
+}
+
+// runCommand loads object files in order and runs them on the terminal
+func runCommand(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	var machine machineFlags
+	machine.register(fs)
+	entry := fs.Int("entry", 1, "start at the origin of the N-th object file, counting from 1")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: [run] [-traps bundles] [-sandbox dir] [-entry N] [-pc addr] [-reg Rn=value] [-trace] [-banner] [-typeahead N] [-overflow policy] [-eof policy] file.obj...")
+	}
+
+	a, err := machine.loadEntry(fs.Args(), *entry)
+	if err != nil {
+		return err
+	}
+
+	return withRawTerminal(func() error {
+		go processInput(a)
+
+		for a.Running {
+			a.EmulateInstruction()
+		}
+		if a.Err != nil {
+			return a.Err
+		}
+		if a.ExitStatus != 0 {
+			return exitStatus(a.ExitStatus)
+		}
+		return nil
+	})
+}
+
+// Exit statuses of the batch command when the program did not exit by itself. They are above
+// MaxExitStatus and the statuses shells report for signals, so no program status matches them.
+const (
+	batchError      = 200 // the program could not be loaded or the machine stopped on an error
+	batchOutOfInput = 201 // the program waited for input after the end of its input
+	batchLimit      = 202 // the program exceeded -max-steps or -timeout
+)
+
+// batchCommand runs object files without a terminal, with input from a file or the command line.
+// It exits with the status of the program or one of the batch statuses, after printing why.
+func batchCommand(args []string) error {
+	err := runBatch(args)
+	if _, ok := err.(exitStatus); err == nil || ok {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, err)
+	return exitStatus(batchError)
+}
+
+func runBatch(args []string) error {
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	var machine machineFlags
+	machine.register(fs)
+	entry := fs.Int("entry", 1, "start at the origin of the N-th object file, counting from 1")
+	inputFile := fs.String("input", "", "file typed as keyboard input, - for standard input")
+	inputText := fs.String("input-text", "", "text typed as keyboard input, after the -input file")
+	output := fs.String("output", "", "file receiving the console output (default: standard output)")
+	maxSteps := fs.Int("max-steps", 0, "stop after executing this many instructions, 0 for no limit")
+	timeout := fs.Duration("timeout", 0, "stop after running this long, 0 for no limit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: batch [-input file] [-input-text text] [-output file] [-max-steps N] [-timeout duration] [-traps bundles] [-sandbox dir] [-entry N] [-pc addr] [-reg Rn=value] [-trace] [-banner] [-typeahead N] [-overflow policy] [-eof policy] file.obj...")
+	}
+
+	var input []byte
+	switch *inputFile {
+	case "":
+	case "-":
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		input = b
+	default:
+		b, err := ioutil.ReadFile(*inputFile)
+		if err != nil {
+			return err
+		}
+		input = b
+	}
+	input = append(input, *inputText...)
+
+	a, err := machine.loadEntry(fs.Args(), *entry)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		a.Out = f
+	}
+
+	b := NewBatch(a, input)
+	b.MaxSteps = *maxSteps
+	b.Timeout = *timeout
+
+	switch err := b.Run(); err {
+	case nil:
+		if a.ExitStatus != 0 {
+			return exitStatus(a.ExitStatus)
+		}
+		return nil
+	case ErrOutOfInput:
+		fmt.Fprintln(os.Stderr, err)
+		return exitStatus(batchOutOfInput)
+	case ErrStepLimit, ErrTimeLimit:
+		fmt.Fprintln(os.Stderr, err)
+		return exitStatus(batchLimit)
+	default:
+		return err
+	}
+}
+
+// asmCommand assembles a source file into an object file and a symbol file
+func asmCommand(args []string) error {
+	fs := flag.NewFlagSet("asm", flag.ContinueOnError)
+	out := fs.String("o", "", "output file (default: source file with .obj extension)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: asm [-o file.obj] file.asm")
+	}
+
+	path := fs.Arg(0)
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	prog, err := Assemble(path, src)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		*out = strings.TrimSuffix(path, filepath.Ext(path)) + ".obj"
+	}
+
+	var buf bytes.Buffer
+	if err := prog.WriteObj(&buf); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	buf.Reset()
+	if err := prog.WriteSym(&buf); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(symbolsPath(*out), buf.Bytes(), 0644)
+}
+
+// convertCommand converts an object file to another format
+func convertCommand(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	out := fs.String("o", "", "output file")
+	format := fs.String("format", "", "output format: raw, lc3tools, hex or bin (default: by the extension of the output file, raw for others)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *out == "" {
+		return fmt.Errorf("usage: convert [-format name] -o output file.obj")
+	}
+
+	f, err := outputFormat(*out, *format)
+	if err != nil {
+		return err
+	}
+
+	var memory [65536]uint16
+	segments, err := Load(&memory, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := WriteImage(&buf, f, &memory, segments); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*out, buf.Bytes(), 0644)
+}
+
+// disasmCommand disassembles an object file, or a range of it
+func disasmCommand(args []string) error {
+	fs := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	var start, end addressFlag
+	fs.Var(&start, "start", "first address to disassemble (default: origin of the file)")
+	fs.Var(&end, "end", "last address to disassemble (default: end of the file)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: disasm [-start addr] [-end addr] file.obj")
+	}
+
+	path := fs.Arg(0)
+	var memory [65536]uint16
+	segments, err := Load(&memory, path)
+	if err != nil {
+		return err
+	}
+	origin, last := segmentsRange(segments)
+	syms, err := LoadSymbols(path)
+	if err != nil {
+		return err
+	}
+
+	if !start.set {
+		start.value = origin
+	}
+	if !end.set {
+		end.value = last
+	}
+
+	return DisassembleRangeSym(os.Stdout, &memory, syms, start.value, end.value)
+}
+
+// debugCommand loads an object file and starts the interactive debugger on it
+func debugCommand(args []string) error {
+	fs := flag.NewFlagSet("debug", flag.ContinueOnError)
+	var machine machineFlags
+	machine.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: debug [-traps bundles] [-sandbox dir] [-pc addr] [-reg Rn=value] [-trace] [-banner] [-typeahead N] [-overflow policy] [-eof policy] file.obj")
+	}
+
+	a, err := machine.newALU()
+	if err != nil {
+		return err
+	}
+	segments, err := loadProgram(a, fs.Args())
+	if err != nil {
+		return err
+	}
+	machine.start(a, segments[0][0].Origin)
+
+	d := NewDebugger(a)
+
+	// Ctrl-C pauses the program instead of leaving the debugger
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		for range interrupts {
+			d.Pause()
+		}
+	}()
+
+	RunDebugCLI(d, os.Stdin, os.Stdout)
+	return nil
+}
+
+// gdbCommand loads an object file and waits for a GDB remote protocol client to debug it
+func gdbCommand(args []string) error {
+	fs := flag.NewFlagSet("gdb", flag.ContinueOnError)
+	listen := fs.String("listen", "localhost:1234", "TCP address to listen on, or unix:PATH for a Unix socket")
+	var machine machineFlags
+	machine.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: gdb [-listen addr] [-traps bundles] [-sandbox dir] [-pc addr] [-reg Rn=value] [-trace] [-banner] [-typeahead N] [-overflow policy] [-eof policy] file.obj")
+	}
+
+	a, err := machine.newALU()
+	if err != nil {
+		return err
+	}
+	segments, err := loadProgram(a, fs.Args())
+	if err != nil {
+		return err
+	}
+	machine.start(a, segments[0][0].Origin)
+
+	network, address := "tcp", *listen
+	if strings.HasPrefix(address, "unix:") {
+		network, address = "unix", strings.TrimPrefix(address, "unix:")
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "waiting for a debugger on %s\n", *listen)
+	conn, err := l.Accept()
+	l.Close()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return withRawTerminal(func() error {
+		go processInput(a)
+		return ServeGDB(NewDebugger(a), conn)
+	})
+}
+
+// dapCommand serves the Debug Adapter Protocol on standard input and output, or on a TCP address
+func dapCommand(args []string) error {
+	fs := flag.NewFlagSet("dap", flag.ContinueOnError)
+	listen := fs.String("listen", "", "TCP address to listen on instead of using standard input and output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *listen == "" {
+		return ServeDAP(os.Stdin, os.Stdout)
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "waiting for a client on %s\n", *listen)
+	conn, err := l.Accept()
+	l.Close()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return ServeDAP(conn, conn)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMachineFlags(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		args []string
+
+		expectedPC   uint16
+		expectedRegs [8]uint16
+		expectedErr  string
+	}{
+		{
+			args:         nil,
+			expectedPC:   0x4000,
+			expectedRegs: [8]uint16{0, 0, 0, 0, 0, 0, SSPStart, 0},
+		},
+		{
+			args:         []string{"-pc", "x4002", "-reg", "R1=x10,r7=#-1", "-reg", "R0=5"},
+			expectedPC:   0x4002,
+			expectedRegs: [8]uint16{5, 0x10, 0, 0, 0, 0, SSPStart, 0xFFFF},
+		},
+		{
+			args:        []string{"-reg", "R8=1"},
+			expectedErr: `invalid value "R8=1" for flag -reg: invalid register assignment "R8=1", expected Rn=VALUE`,
+		},
+		{
+			args:        []string{"-overflow", "middle"},
+			expectedErr: `invalid value "middle" for flag -overflow: invalid overflow policy "middle", expected newest or oldest`,
+		},
+		{
+			args:        []string{"-reg", "R1="},
+			expectedErr: `invalid value "R1=" for flag -reg: invalid value ""`,
+		},
+		{
+			args:        []string{"-eof", ""},
+			expectedErr: `invalid value "" for flag -eof: invalid end of input policy "", expected error, halt or a character like x04`,
+		},
+		{
+			args:        []string{"-eof", "never"},
+			expectedErr: `invalid value "never" for flag -eof: invalid end of input policy "never", expected error, halt or a character like x04`,
+		},
+		{
+			args:        []string{"-pc", "x10000"},
+			expectedErr: `invalid value "x10000" for flag -pc: invalid address "x10000"`,
+		},
+	}
+
+	for _, testData := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		var machine machineFlags
+		machine.register(fs)
+
+		err := fs.Parse(testData.args)
+		if testData.expectedErr != "" {
+			assert.EqualError(err, testData.expectedErr, "Args %v", testData.args)
+			continue
+		}
+		assert.NoError(err, "Args %v", testData.args)
+
+		a, err := machine.newALU()
+		assert.NoError(err)
+		machine.start(a, 0x4000)
+		assert.Equal(testData.expectedPC, a.PCReg, "PC with args %v", testData.args)
+		assert.Equal(testData.expectedRegs, a.Reg, "Registers with args %v", testData.args)
+	}
+}
+
+func TestBatchCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	// echo characters until a newline
+	obj := writeObj(t, dir, "echo.obj", 0x3000, 0xF020, 0xF021, 0x1236, 0x0BFC, 0xF025)
+	input := filepath.Join(dir, "input.txt")
+	assert.NoError(ioutil.WriteFile(input, []byte("ab"), 0644))
+	output := filepath.Join(dir, "output.txt")
+
+	tests := []struct {
+		args []string
+
+		expectedErr    error
+		expectedOutput string
+	}{
+		{
+			args:           []string{"-input-text", "ok\n"},
+			expectedOutput: "ok\n",
+		},
+		{
+			args:           []string{"-input", input, "-input-text", "c\n"},
+			expectedOutput: "abc\n",
+		},
+		{
+			args:           []string{"-input", input},
+			expectedErr:    exitStatus(batchOutOfInput),
+			expectedOutput: "ab",
+		},
+		{
+			args:           []string{"-input", input, "-eof", "x0A"},
+			expectedOutput: "ab\n",
+		},
+		{
+			args:        []string{"-input-text", "ok\n", "-max-steps", "5"},
+			expectedErr: exitStatus(batchLimit),
+		},
+		{
+			args:        []string{"-input-text", "ok\n", "-pc", "x0000"},
+			expectedErr: exitStatus(batchError),
+		},
+	}
+
+	for _, testData := range tests {
+		args := append(testData.args, "-output", output, obj)
+		assert.Equal(testData.expectedErr, batchCommand(args), "Args %v", testData.args)
+
+		b, err := ioutil.ReadFile(output)
+		assert.NoError(err)
+		if testData.expectedOutput != "" {
+			assert.Equal(testData.expectedOutput, string(b), "Args %v", testData.args)
+		}
+	}
+}
@@ -0,0 +1,679 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The adapter exposes the loaded program as a single thread with a single frame.
+// Its source is the disassembly of the object file, served through sourceReference
+// dapSourceRef, where line n holds the word at origin+n-1.
+const (
+	dapThreadID     = 1
+	dapSourceRef    = 1
+	dapRegistersRef = 1
+)
+
+// dapRequest is an incoming Debug Adapter Protocol request
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// dapServer serves the Debug Adapter Protocol for a single session
+type dapServer struct {
+	*Debugger
+
+	r   *bufio.Reader
+	w   io.Writer
+	wmu sync.Mutex
+	seq int
+
+	// mu is held while the program executes, requests that inspect or change
+	// the machine fail instead of waiting for it so that pause is still read
+	mu sync.Mutex
+
+	program      string
+	origin, last uint16
+	stopOnEntry  bool
+
+	sourceBreakpoints      map[uint16]bool
+	instructionBreakpoints map[uint16]bool
+}
+
+// ServeDAP answers Debug Adapter Protocol requests read from r until the client disconnects
+func ServeDAP(r io.Reader, w io.Writer) error {
+	s := &dapServer{
+		r:                      bufio.NewReader(r),
+		w:                      w,
+		sourceBreakpoints:      map[uint16]bool{},
+		instructionBreakpoints: map[uint16]bool{},
+	}
+
+	for {
+		b, err := s.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req dapRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+
+		if done := s.handle(&req); done {
+			return nil
+		}
+	}
+}
+
+func (s *dapServer) readMessage() ([]byte, error) {
+	length := -1
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if v := strings.TrimPrefix(line, "Content-Length:"); v != line {
+			if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return nil, fmt.Errorf("invalid header %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+
+	b := make([]byte, length)
+	_, err := io.ReadFull(s.r, b)
+	return b, err
+}
+
+func (s *dapServer) send(msg map[string]interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	s.seq++
+	msg["seq"] = s.seq
+	b, _ := json.Marshal(msg)
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+}
+
+func (s *dapServer) respond(req *dapRequest, body interface{}) {
+	msg := map[string]interface{}{
+		"type":        "response",
+		"request_seq": req.Seq,
+		"command":     req.Command,
+		"success":     true,
+	}
+	if body != nil {
+		msg["body"] = body
+	}
+	s.send(msg)
+}
+
+func (s *dapServer) fail(req *dapRequest, format string, args ...interface{}) {
+	s.send(map[string]interface{}{
+		"type":        "response",
+		"request_seq": req.Seq,
+		"command":     req.Command,
+		"success":     false,
+		"message":     fmt.Sprintf(format, args...),
+	})
+}
+
+func (s *dapServer) event(name string, body interface{}) {
+	msg := map[string]interface{}{
+		"type":  "event",
+		"event": name,
+	}
+	if body != nil {
+		msg["body"] = body
+	}
+	s.send(msg)
+}
+
+// dapOutput turns console output of the program into output events
+type dapOutput struct {
+	s *dapServer
+}
+
+func (o dapOutput) Write(b []byte) (int, error) {
+	o.s.event("output", map[string]interface{}{"category": "stdout", "output": string(b)})
+	return len(b), nil
+}
+
+// handle answers a request and reports whether the session is over
+func (s *dapServer) handle(req *dapRequest) bool {
+	switch req.Command {
+	case "pause":
+		if s.Debugger != nil {
+			s.Pause()
+		}
+		s.respond(req, nil)
+		return false
+	case "disconnect", "terminate":
+		if s.Debugger != nil {
+			s.Pause()
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.respond(req, nil)
+		if req.Command == "terminate" {
+			s.event("terminated", nil)
+		}
+		return req.Command == "disconnect"
+	case "initialize":
+		s.respond(req, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsInstructionBreakpoints":   true,
+			"supportsDisassembleRequest":       true,
+			"supportsReadMemoryRequest":        true,
+			"supportsWriteMemoryRequest":       true,
+			"supportsSetVariable":              true,
+			"supportsTerminateRequest":         true,
+		})
+		s.event("initialized", nil)
+		return false
+	case "launch":
+		s.launch(req)
+		return false
+	}
+
+	if s.Debugger == nil {
+		s.fail(req, "no program launched")
+		return false
+	}
+
+	if req.Command == "threads" {
+		s.respond(req, map[string]interface{}{
+			"threads": []interface{}{map[string]interface{}{"id": dapThreadID, "name": filepath.Base(s.program)}},
+		})
+		return false
+	}
+
+	if !s.mu.TryLock() {
+		s.fail(req, "the program is running, pause it first")
+		return false
+	}
+	switch req.Command {
+	case "continue":
+		s.resume(req, s.Continue)
+		return false
+	case "next":
+		s.resume(req, s.Next)
+		return false
+	case "stepIn":
+		s.resume(req, s.Step)
+		return false
+	case "stepOut":
+		s.resume(req, s.Finish)
+		return false
+	case "configurationDone":
+		s.respond(req, nil)
+		if s.stopOnEntry {
+			s.mu.Unlock()
+			s.event("stopped", map[string]interface{}{"reason": "entry", "threadId": dapThreadID, "allThreadsStopped": true})
+		} else {
+			s.start(s.Continue)
+		}
+		return false
+	}
+	defer s.mu.Unlock()
+
+	switch req.Command {
+	case "setBreakpoints":
+		s.setBreakpoints(req)
+	case "setInstructionBreakpoints":
+		s.setInstructionBreakpoints(req)
+	case "stackTrace":
+		s.stackTrace(req)
+	case "scopes":
+		s.respond(req, map[string]interface{}{
+			"scopes": []interface{}{map[string]interface{}{"name": "Registers", "variablesReference": dapRegistersRef}},
+		})
+	case "variables":
+		s.respond(req, map[string]interface{}{"variables": s.registers()})
+	case "setVariable":
+		s.setVariable(req)
+	case "source":
+		var b bytes.Buffer
+		for addr := int(s.origin); addr <= int(s.last); addr++ {
+			if label, ok := s.ALU.Symbols.Label(uint16(addr)); ok {
+				fmt.Fprintf(&b, "%s: ", label)
+			}
+			fmt.Fprintln(&b, DisassembleSym(s.ALU.Peek(uint16(addr)), uint16(addr), s.ALU.Symbols))
+		}
+		s.respond(req, map[string]interface{}{"content": b.String(), "mimeType": "text/x-lc3"})
+	case "disassemble":
+		s.disassemble(req)
+	case "readMemory":
+		s.readMemory(req)
+	case "writeMemory":
+		s.writeMemory(req)
+	case "evaluate":
+		s.evaluate(req)
+	default:
+		s.fail(req, "unsupported request %s", req.Command)
+	}
+	return false
+}
+
+func (s *dapServer) launch(req *dapRequest) {
+	var args struct {
+		Program     string   `json:"program"`
+		Input       string   `json:"input"`
+		StopOnEntry bool     `json:"stopOnEntry"`
+		Traps       []string `json:"traps"`
+		Sandbox     string   `json:"sandbox"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, "invalid arguments: %v", err)
+		return
+	}
+
+	machine := machineFlags{traps: args.Traps, sandbox: args.Sandbox}
+	a, err := machine.newALU()
+	if err != nil {
+		s.fail(req, "%v", err)
+		return
+	}
+	segments, err := Load(&a.Memory, args.Program)
+	if err != nil {
+		s.fail(req, "%v", err)
+		return
+	}
+	if a.Symbols, err = LoadSymbols(args.Program); err != nil {
+		s.fail(req, "%v", err)
+		return
+	}
+	origin, last := segmentsRange(segments)
+	a.PCReg = segments[0].Origin
+	a.Out = dapOutput{s}
+
+	s.Debugger = NewDebugger(a)
+	s.Input = []byte(args.Input)
+	s.program, s.origin, s.last = args.Program, origin, last
+	s.stopOnEntry = args.StopOnEntry
+	s.respond(req, nil)
+}
+
+// resume answers an execution request and runs f in the background
+func (s *dapServer) resume(req *dapRequest, f func() StopReason) {
+	s.respond(req, map[string]interface{}{"allThreadsContinued": true})
+	s.start(f)
+}
+
+// start runs f in the background. s.mu must be held; it is released once f returns.
+func (s *dapServer) start(f func() StopReason) {
+	go func() {
+		reason := f()
+		s.mu.Unlock()
+		s.stopped(reason)
+	}()
+}
+
+func (s *dapServer) stopped(reason StopReason) {
+	body := map[string]interface{}{"threadId": dapThreadID, "allThreadsStopped": true}
+
+	switch reason {
+	case StopHalted:
+		if s.ALU.Err != nil {
+			s.event("output", map[string]interface{}{"category": "stderr", "output": s.ALU.Err.Error() + "\n"})
+		}
+		s.event("terminated", nil)
+		s.event("exited", map[string]interface{}{"exitCode": s.ALU.ExitCode()})
+		return
+	case StopBreakpoint:
+		body["reason"] = "breakpoint"
+	case StopPaused:
+		body["reason"] = "pause"
+	case StopInput:
+		body["reason"] = "pause"
+		body["description"] = "Waiting for input"
+		s.event("output", map[string]interface{}{
+			"category": "console",
+			"output":   "The program waits for keyboard input, enter: type TEXT\n",
+		})
+	default:
+		body["reason"] = "step"
+	}
+	s.event("stopped", body)
+}
+
+// line returns the line of addr in the disassembly source, or 0 if it is outside the program
+func (s *dapServer) line(addr uint16) int {
+	if addr < s.origin || addr > s.last {
+		return 0
+	}
+	return int(addr-s.origin) + 1
+}
+
+func (s *dapServer) source() map[string]interface{} {
+	return map[string]interface{}{
+		"name":             filepath.Base(s.program) + ".asm",
+		"sourceReference":  dapSourceRef,
+		"presentationHint": "deemphasize",
+	}
+}
+
+func (s *dapServer) updateBreakpoints() {
+	s.Breakpoints = map[uint16]bool{}
+	for addr := range s.sourceBreakpoints {
+		s.Breakpoints[addr] = true
+	}
+	for addr := range s.instructionBreakpoints {
+		s.Breakpoints[addr] = true
+	}
+}
+
+func (s *dapServer) setBreakpoints(req *dapRequest) {
+	var args struct {
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, "invalid arguments: %v", err)
+		return
+	}
+
+	s.sourceBreakpoints = map[uint16]bool{}
+	result := []interface{}{}
+	for _, bp := range args.Breakpoints {
+		addr := int(s.origin) + bp.Line - 1
+		verified := bp.Line >= 1 && addr <= int(s.last)
+		if verified {
+			s.sourceBreakpoints[uint16(addr)] = true
+		}
+		result = append(result, map[string]interface{}{"verified": verified, "line": bp.Line, "source": s.source()})
+	}
+	s.updateBreakpoints()
+	s.respond(req, map[string]interface{}{"breakpoints": result})
+}
+
+func (s *dapServer) setInstructionBreakpoints(req *dapRequest) {
+	var args struct {
+		Breakpoints []struct {
+			InstructionReference string `json:"instructionReference"`
+			Offset               int    `json:"offset"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, "invalid arguments: %v", err)
+		return
+	}
+
+	s.instructionBreakpoints = map[uint16]bool{}
+	result := []interface{}{}
+	for _, bp := range args.Breakpoints {
+		addr, ok := s.ALU.Symbols.Resolve(bp.InstructionReference)
+		if ok {
+			addr += uint16(bp.Offset)
+			s.instructionBreakpoints[addr] = true
+		}
+		result = append(result, map[string]interface{}{"verified": ok, "instructionReference": fmt.Sprintf("0x%04X", addr)})
+	}
+	s.updateBreakpoints()
+	s.respond(req, map[string]interface{}{"breakpoints": result})
+}
+
+func (s *dapServer) stackTrace(req *dapRequest) {
+	pc := s.ALU.PCReg
+	frame := map[string]interface{}{
+		"id":                          1,
+		"name":                        fmt.Sprintf("%s  %s", s.ALU.Symbols.Describe(pc), DisassembleSym(s.ALU.Peek(pc), pc, s.ALU.Symbols)),
+		"line":                        s.line(pc),
+		"column":                      1,
+		"instructionPointerReference": fmt.Sprintf("0x%04X", pc),
+	}
+	if s.line(pc) != 0 {
+		frame["source"] = s.source()
+	}
+	s.respond(req, map[string]interface{}{"stackFrames": []interface{}{frame}, "totalFrames": 1})
+}
+
+func (s *dapServer) registers() []interface{} {
+	a := s.ALU
+	vars := []interface{}{}
+	for i, r := range a.Reg {
+		vars = append(vars, dapVariable(fmt.Sprintf("R%d", i), fmt.Sprintf("x%04X (%d)", r, int16(r)), r))
+	}
+	vars = append(vars, dapVariable("PC", fmt.Sprintf("x%04X", a.PCReg), a.PCReg))
+	vars = append(vars, map[string]interface{}{"name": "COND", "value": condString(a.CondReg), "variablesReference": 0})
+	vars = append(vars, map[string]interface{}{"name": "PSR", "value": fmt.Sprintf("x%04X", a.PSR()), "variablesReference": 0})
+	return vars
+}
+
+func dapVariable(name, value string, addr uint16) map[string]interface{} {
+	return map[string]interface{}{
+		"name":               name,
+		"value":              value,
+		"variablesReference": 0,
+		"memoryReference":    fmt.Sprintf("0x%04X", addr),
+	}
+}
+
+func (s *dapServer) setVariable(req *dapRequest) {
+	var args struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, "invalid arguments: %v", err)
+		return
+	}
+
+	value := strings.TrimSpace(args.Value)
+	if value == "" {
+		s.fail(req, "missing value")
+		return
+	}
+	v, ok := parseNumber(value)
+	if !ok || v < -0x8000 || v > 0xFFFF {
+		s.fail(req, "invalid value %q", args.Value)
+		return
+	}
+
+	if r, ok := registerIndex(args.Name); ok {
+		s.ALU.Reg[r] = uint16(v)
+	} else if args.Name == "PC" {
+		s.ALU.PCReg = uint16(v)
+	} else if args.Name == "PSR" {
+		s.ALU.SetPSR(uint16(v))
+	} else {
+		s.fail(req, "%s cannot be modified", args.Name)
+		return
+	}
+	s.respond(req, map[string]interface{}{"value": fmt.Sprintf("x%04X", uint16(v))})
+}
+
+func (s *dapServer) disassemble(req *dapRequest) {
+	var args struct {
+		MemoryReference   string `json:"memoryReference"`
+		Offset            int    `json:"offset"`
+		InstructionOffset int    `json:"instructionOffset"`
+		InstructionCount  int    `json:"instructionCount"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, "invalid arguments: %v", err)
+		return
+	}
+	addr, ok := parseMemoryReference(args.MemoryReference)
+	if !ok {
+		s.fail(req, "invalid memory reference %q", args.MemoryReference)
+		return
+	}
+	addr += uint16(args.Offset/2 + args.InstructionOffset)
+
+	instructions := []interface{}{}
+	for i := 0; i < args.InstructionCount; i++ {
+		instr := map[string]interface{}{
+			"address":          fmt.Sprintf("0x%04X", addr),
+			"instructionBytes": fmt.Sprintf("%04X", s.ALU.Peek(addr)),
+			"instruction":      DisassembleSym(s.ALU.Peek(addr), addr, s.ALU.Symbols),
+		}
+		if label, ok := s.ALU.Symbols.Label(addr); ok {
+			instr["symbol"] = label
+		}
+		if line := s.line(addr); line != 0 {
+			instr["location"] = s.source()
+			instr["line"] = line
+		}
+		instructions = append(instructions, instr)
+		addr++
+	}
+	s.respond(req, map[string]interface{}{"instructions": instructions})
+}
+
+// Memory references are word addresses. The memory they refer to is read and written
+// as bytes, two per word in little-endian order, so offsets and counts are in bytes.
+func (s *dapServer) readMemory(req *dapRequest) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Count           int    `json:"count"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, "invalid arguments: %v", err)
+		return
+	}
+	addr, ok := parseMemoryReference(args.MemoryReference)
+	if !ok {
+		s.fail(req, "invalid memory reference %q", args.MemoryReference)
+		return
+	}
+
+	if args.Count < 0 {
+		s.fail(req, "invalid count %d", args.Count)
+		return
+	}
+
+	start := int(addr)*2 + args.Offset
+	switch {
+	case start < 0:
+		start = 0
+	case start > 0x20000:
+		start = 0x20000
+	}
+	end := start + args.Count
+	if end > 0x20000 {
+		end = 0x20000
+	}
+
+	var b []byte
+	for i := start; i < end; i++ {
+		b = append(b, s.readByte(i))
+	}
+	s.respond(req, map[string]interface{}{
+		"address": fmt.Sprintf("0x%04X", start/2),
+		"data":    base64.StdEncoding.EncodeToString(b),
+	})
+}
+
+func (s *dapServer) writeMemory(req *dapRequest) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Data            string `json:"data"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, "invalid arguments: %v", err)
+		return
+	}
+	addr, ok := parseMemoryReference(args.MemoryReference)
+	b, err := base64.StdEncoding.DecodeString(args.Data)
+	if !ok || err != nil {
+		s.fail(req, "invalid memory reference or data")
+		return
+	}
+
+	start := int(addr)*2 + args.Offset
+	if start < 0 || start+len(b) > 0x20000 {
+		s.fail(req, "write outside of memory")
+		return
+	}
+	s.writeBytes(start, b)
+	s.respond(req, map[string]interface{}{"bytesWritten": len(b)})
+}
+
+// evaluate runs debugger commands from the debug console and resolves
+// registers, addresses and labels for watch and hover expressions
+func (s *dapServer) evaluate(req *dapRequest) {
+	var args struct {
+		Expression string `json:"expression"`
+		Context    string `json:"context"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, "invalid arguments: %v", err)
+		return
+	}
+	expr := strings.TrimSpace(args.Expression)
+
+	if args.Context == "repl" {
+		fields := strings.Fields(expr)
+		if len(fields) == 0 {
+			s.respond(req, map[string]interface{}{"result": "", "variablesReference": 0})
+			return
+		}
+		switch fields[0] {
+		case "step", "s", "next", "n", "finish", "f", "continue", "c", "quit", "q":
+			s.fail(req, "use the debugger controls to run the program")
+			return
+		}
+
+		var out bytes.Buffer
+		cli := &debugCLI{Debugger: s.Debugger, out: &out}
+		if err := cli.exec(fields[0], fields[1:], expr); err != nil {
+			s.fail(req, "%v", err)
+			return
+		}
+		s.respond(req, map[string]interface{}{"result": strings.TrimRight(out.String(), "\n"), "variablesReference": 0})
+		return
+	}
+
+	var v uint16
+	if r, ok := registerIndex(expr); ok {
+		v = s.ALU.Reg[r]
+	} else if strings.EqualFold(expr, "PC") {
+		v = s.ALU.PCReg
+	} else if addr, ok := s.ALU.Symbols.Resolve(expr); ok {
+		v = s.ALU.Peek(addr)
+	} else {
+		s.fail(req, "cannot evaluate %q", expr)
+		return
+	}
+	s.respond(req, map[string]interface{}{"result": fmt.Sprintf("x%04X (%d)", v, int16(v)), "variablesReference": 0})
+}
+
+// parseMemoryReference parses a word address written as 0x3000 or x3000
+func parseMemoryReference(ref string) (uint16, bool) {
+	if ref == "" {
+		return 0, false
+	}
+	v, ok := parseNumber(ref)
+	if !ok || v < 0 || v > 0xFFFF {
+		return 0, false
+	}
+	return uint16(v), true
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dapClient exchanges messages with an adapter over pipes
+type dapClient struct {
+	t   *testing.T
+	w   io.Writer
+	r   *bufio.Reader
+	seq int
+}
+
+func (c *dapClient) request(command string, args interface{}) {
+	c.seq++
+	b, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+}
+
+// next reads messages until one of the given type and event or command name arrives
+func (c *dapClient) next(typ, name string) map[string]interface{} {
+	for {
+		header, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		length, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "Content-Length:")))
+		c.r.ReadString('\n')
+
+		b := make([]byte, length)
+		io.ReadFull(c.r, b)
+
+		var msg map[string]interface{}
+		json.Unmarshal(b, &msg)
+		if msg["type"] == typ && (msg["event"] == name || msg["command"] == name) {
+			return msg
+		}
+	}
+}
+
+func (c *dapClient) body(typ, name string) map[string]interface{} {
+	body, _ := c.next(typ, name)["body"].(map[string]interface{})
+	return body
+}
+
+func TestDAPSession(t *testing.T) {
+	assert := assert.New(t)
+
+	prog, err := Assemble("hello.asm", []byte(`
+        .ORIG x3000
+        LEA R0, MSG
+        PUTS
+        ADD R1, R1, #5
+        HALT
+MSG     .STRINGZ "hi"
+        .END
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var obj bytes.Buffer
+	prog.WriteObj(&obj)
+	path := filepath.Join(t.TempDir(), "hello.obj")
+	ioutil.WriteFile(path, obj.Bytes(), 0644)
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- ServeDAP(inR, outW)
+	}()
+
+	c := &dapClient{t: t, w: inW, r: bufio.NewReader(outR)}
+
+	c.request("initialize", map[string]interface{}{"adapterID": "lc3"})
+	assert.Equal(true, c.body("response", "initialize")["supportsInstructionBreakpoints"])
+	c.next("event", "initialized")
+
+	c.request("launch", map[string]interface{}{"program": path})
+	assert.Equal(true, c.next("response", "launch")["success"])
+
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"sourceReference": dapSourceRef},
+		"breakpoints": []interface{}{map[string]interface{}{"line": 3}},
+	})
+	c.next("response", "setBreakpoints")
+
+	c.request("configurationDone", nil)
+	assert.Equal("stdout", c.body("event", "output")["category"])
+	assert.Equal("breakpoint", c.body("event", "stopped")["reason"])
+
+	c.request("stackTrace", map[string]interface{}{"threadId": dapThreadID})
+	frame := c.body("response", "stackTrace")["stackFrames"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(float64(3), frame["line"])
+	assert.Equal("0x3002", frame["instructionPointerReference"])
+
+	c.request("readMemory", map[string]interface{}{"memoryReference": "0x3004", "count": 4})
+	assert.Equal("aABpAA==", c.body("response", "readMemory")["data"])
+	c.request("readMemory", map[string]interface{}{"memoryReference": "0xFFFF", "offset": 10, "count": 4})
+	assert.Equal("", c.body("response", "readMemory")["data"])
+	c.request("readMemory", map[string]interface{}{"memoryReference": "0x3004", "count": -5})
+	assert.Equal(false, c.next("response", "readMemory")["success"])
+	c.request("setVariable", map[string]interface{}{"variablesReference": dapRegistersRef, "name": "R1", "value": ""})
+	assert.Equal(false, c.next("response", "setVariable")["success"])
+
+	c.request("next", map[string]interface{}{"threadId": dapThreadID})
+	c.next("event", "stopped")
+
+	c.request("variables", map[string]interface{}{"variablesReference": dapRegistersRef})
+	r1 := c.body("response", "variables")["variables"].([]interface{})[1].(map[string]interface{})
+	assert.Equal("x0005 (5)", r1["value"])
+
+	c.request("continue", map[string]interface{}{"threadId": dapThreadID})
+	c.next("event", "terminated")
+
+	c.request("disconnect", nil)
+	c.next("response", "disconnect")
+	assert.NoError(<-done)
+}
+
+func TestDAPPauseRunning(t *testing.T) {
+	assert := assert.New(t)
+
+	prog, err := Assemble("loop.asm", []byte(`
+        .ORIG x3000
+        AND R0, R0, #0
+LOOP    BRnzp LOOP
+        .END
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var obj bytes.Buffer
+	prog.WriteObj(&obj)
+	path := filepath.Join(t.TempDir(), "loop.obj")
+	ioutil.WriteFile(path, obj.Bytes(), 0644)
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- ServeDAP(inR, outW)
+	}()
+
+	c := &dapClient{t: t, w: inW, r: bufio.NewReader(outR)}
+
+	c.request("initialize", map[string]interface{}{"adapterID": "lc3"})
+	c.next("event", "initialized")
+	c.request("launch", map[string]interface{}{"program": path})
+	c.next("response", "launch")
+	c.request("configurationDone", nil)
+	c.next("response", "configurationDone")
+
+	c.request("threads", nil)
+	assert.Equal(true, c.next("response", "threads")["success"])
+	c.request("stackTrace", map[string]interface{}{"threadId": dapThreadID})
+	assert.Equal(false, c.next("response", "stackTrace")["success"])
+
+	c.request("pause", map[string]interface{}{"threadId": dapThreadID})
+	assert.Equal("pause", c.body("event", "stopped")["reason"])
+
+	c.request("stackTrace", map[string]interface{}{"threadId": dapThreadID})
+	assert.Equal(true, c.next("response", "stackTrace")["success"])
+
+	c.request("disconnect", nil)
+	c.next("response", "disconnect")
+	assert.NoError(<-done)
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// StopReason tells why the debugger stopped executing instructions
+type StopReason int
+
+const (
+	StopStep       StopReason = iota // the requested steps completed
+	StopBreakpoint                   // PC reached a breakpoint
+	StopHalted                       // the machine is no longer running
+	StopInput                        // the program waits for keyboard input that was not typed yet
+	StopPaused                       // Pause was called
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopHalted:
+		return "halted"
+	case StopInput:
+		return "waiting for input"
+	case StopPaused:
+		return "paused"
+	}
+	return "step"
+}
+
+// Debugger controls the execution of an ALU instruction by instruction
+type Debugger struct {
+	ALU         *ALU
+	Breakpoints map[uint16]bool
+
+	// Input holds characters typed for the program; they are delivered to the keyboard one at a time.
+	// GETS implemented in Go only runs once a whole line was typed and consumes it while it runs.
+	Input []byte
+
+	paused int32
+}
+
+// NewDebugger returns a debugger for the given machine without any breakpoints
+func NewDebugger(a *ALU) *Debugger {
+	d := &Debugger{
+		ALU:         a,
+		Breakpoints: map[uint16]bool{},
+	}
+	a.keyWait = d.typeKey
+	return d
+}
+
+// Pause stops a running Continue, Next or Finish, or the next one if none is running.
+// It may be called from another goroutine.
+func (d *Debugger) Pause() {
+	atomic.StoreInt32(&d.paused, 1)
+}
+
+// pausePending reports and clears a request made by Pause
+func (d *Debugger) pausePending() bool {
+	return atomic.CompareAndSwapInt32(&d.paused, 1, 0)
+}
+
+// Step executes a single instruction
+func (d *Debugger) Step() StopReason {
+	a := d.ALU
+	if !a.Running {
+		return StopHalted
+	}
+
+	a.drainEvents()
+	if a.Peek(KBSR)&0x8000 == 0 {
+		d.typeKey()
+	}
+	if d.waitsForInput() {
+		return StopInput
+	}
+
+	a.EmulateInstruction()
+
+	if !a.Running {
+		return StopHalted
+	}
+	return StopStep
+}
+
+// typeKey delivers the next character of Input to the keyboard, if there is one
+func (d *Debugger) typeKey() bool {
+	if len(d.Input) == 0 {
+		return false
+	}
+	d.ALU.pressKey(uint16(d.Input[0]))
+	d.Input = d.Input[1:]
+	return true
+}
+
+// waitsForInput reports whether the next instruction would block reading the keyboard:
+// GETC and IN need a key, GETS implemented in Go a whole line
+func (d *Debugger) waitsForInput() bool {
+	a := d.ALU
+	if a.keyboard.ended {
+		// after the end of the input the trap applies the EOF policy
+		return false
+	}
+	if a.waitsForKey() {
+		return true
+	}
+
+	instr := Decode(a.Peek(a.PCReg))
+	if _, gets := a.traps[TrapGETS]; !gets || instr.Op != OpTRAP || instr.TrapVect != TrapGETS {
+		return false
+	}
+	return !a.keyboard.hasLine() && bytes.IndexAny(d.Input, "\n\r") < 0
+}
+
+// Continue executes instructions until a breakpoint is reached or the machine stops
+func (d *Debugger) Continue() StopReason {
+	return d.run(func(int) bool { return false })
+}
+
+// Next executes one instruction, running subroutines and traps called by it to completion
+func (d *Debugger) Next() StopReason {
+	return d.run(func(depth int) bool { return depth <= 0 })
+}
+
+// Finish executes instructions until the current subroutine returns
+func (d *Debugger) Finish() StopReason {
+	return d.run(func(depth int) bool { return depth < 0 })
+}
+
+// run steps until done returns true for the current call depth, relative to the starting one.
+// Breakpoints are only checked after the first instruction so that execution can leave them.
+func (d *Debugger) run(done func(depth int) bool) StopReason {
+	depth := 0
+	for {
+		instr := Decode(d.ALU.Peek(d.ALU.PCReg))
+		// traps implemented in Go complete within a single step
+		_, native := d.ALU.traps[uint8(instr.TrapVect)]
+		entered := d.ALU.entered
+
+		if reason := d.Step(); reason != StopStep {
+			return reason
+		}
+
+		// handlers return with RTI like traps, so entering one is a call
+		switch {
+		case d.ALU.entered != entered:
+			depth += d.ALU.entered - entered
+		case instr.Op == OpJSR, instr.Op == OpTRAP && !native:
+			depth++
+		case instr.Op == OpJMP && instr.BaseR == 7, instr.Op == OpRTI:
+			depth--
+		}
+
+		if done(depth) {
+			return StopStep
+		}
+		if d.Breakpoints[d.ALU.PCReg] {
+			return StopBreakpoint
+		}
+		if d.pausePending() {
+			return StopPaused
+		}
+	}
+}
+
+// readByte reads byte addr of memory seen as bytes, two per word in little-endian order,
+// the way remote debuggers address it
+func (d *Debugger) readByte(addr int) byte {
+	return byte(d.ALU.Peek(uint16(addr/2)) >> (8 * uint(addr%2)))
+}
+
+// writeBytes writes b from byte addr of memory seen as bytes, see readByte.
+// Every word is written once, so device registers see whole words.
+func (d *Debugger) writeBytes(addr int, b []byte) {
+	for i := 0; i < len(b); {
+		word := d.ALU.Peek(uint16((addr + i) / 2))
+		for ; i < len(b); i++ {
+			shift := 8 * uint((addr+i)%2)
+			word = word&^(0xFF<<shift) | uint16(b[i])<<shift
+			if (addr+i)%2 == 1 {
+				i++
+				break
+			}
+		}
+		d.ALU.Write(uint16((addr+i-1)/2), word)
+	}
+}
+
+// debugCLI is a line-oriented front end for a Debugger
+type debugCLI struct {
+	*Debugger
+	out io.Writer
+}
+
+var debugHelp = `Commands:
+  break ADDR        set a breakpoint (b), addresses may be labels like LOOP or LOOP+2
+  delete ADDR       remove a breakpoint (d)
+  info              list breakpoints
+  step [N]          execute N instructions (s)
+  next              step over JSR, JSRR and TRAP (n)
+  finish            run until the current subroutine returns (f)
+  continue          run until a breakpoint or halt (c)
+  regs              show registers (r)
+  x ADDR [N]        examine N words of memory
+  set ADDR|Rn VAL   modify memory or a register
+  pc ADDR           set the program counter
+  type TEXT         queue keyboard input for the program, "quoted" text may use escapes
+  save FILE START END [FORMAT]
+                    write memory to an object file, see the convert command for formats
+  quit              leave the debugger (q)
+An empty line repeats the previous command.
+`
+
+// RunDebugCLI reads debugger commands from in until EOF or quit
+func RunDebugCLI(d *Debugger, in io.Reader, out io.Writer) {
+	cli := &debugCLI{Debugger: d, out: out}
+	cli.where()
+
+	scanner := bufio.NewScanner(in)
+	last := ""
+	for {
+		fmt.Fprint(out, "(lc3) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			line = last
+		}
+		last = line
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "q" {
+			return
+		}
+		if err := cli.exec(fields[0], fields[1:], line); err != nil {
+			fmt.Fprintln(out, err)
+		}
+	}
+}
+
+func (c *debugCLI) exec(cmd string, args []string, line string) error {
+	switch cmd {
+	case "help", "h":
+		fmt.Fprint(c.out, debugHelp)
+	case "break", "b":
+		addr, err := c.argValue(args, 0)
+		if err != nil {
+			return err
+		}
+		c.Breakpoints[addr] = true
+		fmt.Fprintf(c.out, "breakpoint at %s\n", c.ALU.Symbols.Describe(addr))
+	case "delete", "d":
+		addr, err := c.argValue(args, 0)
+		if err != nil {
+			return err
+		}
+		if !c.Breakpoints[addr] {
+			return fmt.Errorf("no breakpoint at %s", c.ALU.Symbols.Describe(addr))
+		}
+		delete(c.Breakpoints, addr)
+	case "info":
+		var addrs []int
+		for addr := range c.Breakpoints {
+			addrs = append(addrs, int(addr))
+		}
+		sort.Ints(addrs)
+		for _, addr := range addrs {
+			fmt.Fprintf(c.out, "%s  %s\n", c.ALU.Symbols.Describe(uint16(addr)), DisassembleSym(c.ALU.Peek(uint16(addr)), uint16(addr), c.ALU.Symbols))
+		}
+	case "step", "s":
+		n := uint16(1)
+		if len(args) > 0 {
+			v, err := c.argValue(args, 0)
+			if err != nil {
+				return err
+			}
+			n = v
+		}
+		reason := StopStep
+		for i := uint16(0); i < n && reason == StopStep; i++ {
+			reason = c.Step()
+		}
+		c.stopped(reason)
+	case "next", "n":
+		c.stopped(c.Next())
+	case "finish", "f":
+		c.stopped(c.Finish())
+	case "continue", "c":
+		c.stopped(c.Continue())
+	case "regs", "r":
+		c.regs()
+	case "x":
+		addr, err := c.argValue(args, 0)
+		if err != nil {
+			return err
+		}
+		n := uint16(1)
+		if len(args) > 1 {
+			if n, err = c.argValue(args, 1); err != nil {
+				return err
+			}
+		}
+		return disassembleRange(c.out, c.ALU.Peek, c.ALU.Symbols, addr, addr+n-1)
+	case "set":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: set ADDR|Rn VALUE")
+		}
+		val, err := c.argValue(args, 1)
+		if err != nil {
+			return err
+		}
+		if r, ok := registerIndex(args[0]); ok {
+			c.ALU.Reg[r] = val
+			return nil
+		}
+		addr, err := c.argValue(args, 0)
+		if err != nil {
+			return err
+		}
+		c.ALU.Write(addr, val)
+	case "pc":
+		addr, err := c.argValue(args, 0)
+		if err != nil {
+			return err
+		}
+		c.ALU.PCReg = addr
+		c.where()
+	case "save":
+		if len(args) < 3 || len(args) > 4 {
+			return fmt.Errorf("usage: save FILE START END [FORMAT]")
+		}
+		start, err := c.argValue(args, 1)
+		if err != nil {
+			return err
+		}
+		end, err := c.argValue(args, 2)
+		if err != nil {
+			return err
+		}
+		if end < start {
+			return fmt.Errorf("end x%04X is before start x%04X", end, start)
+		}
+		name := ""
+		if len(args) == 4 {
+			name = args[3]
+		}
+		format, err := outputFormat(args[0], name)
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := WriteImage(&buf, format, &c.ALU.Memory, []Segment{{start, int(end-start) + 1}}); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(args[0], buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "saved x%04X-x%04X to %s\n", start, end, args[0])
+	case "type":
+		text := strings.TrimSpace(strings.TrimPrefix(line, cmd))
+		if strings.HasPrefix(text, "\"") {
+			unquoted, err := strconv.Unquote(text)
+			if err != nil {
+				return fmt.Errorf("invalid text %s", text)
+			}
+			text = unquoted
+		}
+		c.Input = append(c.Input, text...)
+	default:
+		return fmt.Errorf("unknown command %q, try help", cmd)
+	}
+	return nil
+}
+
+// argValue parses the i-th argument as an address or a value, which may use labels
+func (c *debugCLI) argValue(args []string, i int) (uint16, error) {
+	if i >= len(args) {
+		return 0, fmt.Errorf("missing argument")
+	}
+	v, ok := c.ALU.Symbols.Resolve(args[i])
+	if !ok {
+		return 0, fmt.Errorf("invalid value %q", args[i])
+	}
+	return v, nil
+}
+
+// registerIndex parses a register name R0-R7
+func registerIndex(s string) (int, bool) {
+	if len(s) == 2 && (s[0] == 'R' || s[0] == 'r') && s[1] >= '0' && s[1] <= '7' {
+		return int(s[1] - '0'), true
+	}
+	return 0, false
+}
+
+func (c *debugCLI) stopped(reason StopReason) {
+	switch reason {
+	case StopBreakpoint:
+		fmt.Fprintf(c.out, "breakpoint at %s\n", c.ALU.Symbols.Describe(c.ALU.PCReg))
+	case StopHalted:
+		switch {
+		case c.ALU.Err != nil:
+			fmt.Fprintf(c.out, "program stopped: %v\n", c.ALU.Err)
+		case c.ALU.ExitStatus != 0:
+			fmt.Fprintf(c.out, "program exited with status %d\n", c.ALU.ExitStatus)
+		default:
+			fmt.Fprintln(c.out, "program halted")
+		}
+		return
+	case StopInput:
+		fmt.Fprintln(c.out, "program waits for input, use type")
+	case StopPaused:
+		fmt.Fprintln(c.out, "paused")
+	}
+	c.where()
+}
+
+// where prints the next instruction
+func (c *debugCLI) where() {
+	pc := c.ALU.PCReg
+	instr := c.ALU.Peek(pc)
+	fmt.Fprintf(c.out, "%s  x%04X  %s\n", c.ALU.Symbols.Describe(pc), instr, DisassembleSym(instr, pc, c.ALU.Symbols))
+}
+
+func (c *debugCLI) regs() {
+	a := c.ALU
+	for i := 0; i < 8; i += 4 {
+		fmt.Fprintf(c.out, "R%d x%04X  R%d x%04X  R%d x%04X  R%d x%04X\n",
+			i, a.Reg[i], i+1, a.Reg[i+1], i+2, a.Reg[i+2], i+3, a.Reg[i+3])
+	}
+	mode := "supervisor"
+	if a.User {
+		mode = "user"
+	}
+	fmt.Fprintf(c.out, "PC x%04X  COND %s  PSR x%04X (%s, priority %d)\n", a.PCReg, condString(a.CondReg), a.PSR(), mode, a.Priority)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assembleALU returns a machine with the given source loaded and PCReg set to its origin
+func assembleALU(t *testing.T, src string) *ALU {
+	prog, err := Assemble("test.asm", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewALU()
+	a.Out = ioutil.Discard
+	copy(a.Memory[prog.Origin:], prog.Words)
+	a.PCReg = prog.Origin
+	return a
+}
+
+const debuggerTestProgram = `
+        .ORIG x3000
+        AND R0, R0, #0
+        JSR INC
+        JSR INC
+        HALT
+INC     ADD R0, R0, #1
+        ADD R0, R0, #0
+        RET
+        .END
+`
+
+func TestDebuggerBreakpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	d := NewDebugger(assembleALU(t, debuggerTestProgram))
+	d.Breakpoints[0x3005] = true
+
+	assert.Equal(StopBreakpoint, d.Continue())
+	assert.Equal(uint16(0x3005), d.ALU.PCReg)
+	assert.Equal(uint16(1), d.ALU.Reg[0])
+
+	assert.Equal(StopBreakpoint, d.Continue())
+	assert.Equal(uint16(2), d.ALU.Reg[0])
+
+	assert.Equal(StopHalted, d.Continue())
+	assert.False(d.ALU.Running)
+}
+
+func TestDebuggerBreakpointInHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	a := assembleALU(t, `
+        .ORIG x3000
+        LD R1, IE
+        STI R1, KBSRA
+LOOP    ADD R3, R3, #0
+        BRz LOOP
+        HALT
+HANDLER LDI R3, KBDRA
+        RTI
+IE      .FILL x4000
+KBSRA   .FILL xFE00
+KBDRA   .FILL xFE02
+        .END
+`)
+	a.Memory[IntVectorTable+IntKeyboard] = 0x3005
+	d := NewDebugger(a)
+	d.Breakpoints[0x3005] = true
+
+	a.pressKey('k')
+	assert.Equal(StopBreakpoint, d.Continue())
+	assert.Equal(uint16(0x3005), a.PCReg)
+	assert.Equal(uint16(0), a.Reg[3], "The handler should stop before its first instruction")
+}
+
+func TestDebuggerNextFinish(t *testing.T) {
+	assert := assert.New(t)
+
+	d := NewDebugger(assembleALU(t, debuggerTestProgram))
+
+	assert.Equal(StopStep, d.Step())
+	assert.Equal(StopStep, d.Next())
+	assert.Equal(uint16(0x3002), d.ALU.PCReg)
+	assert.Equal(uint16(1), d.ALU.Reg[0])
+
+	assert.Equal(StopStep, d.Step())
+	assert.Equal(uint16(0x3004), d.ALU.PCReg)
+	assert.Equal(StopStep, d.Finish())
+	assert.Equal(uint16(0x3003), d.ALU.PCReg)
+	assert.Equal(uint16(2), d.ALU.Reg[0])
+}
+
+func TestDebuggerFinishInterrupted(t *testing.T) {
+	assert := assert.New(t)
+
+	a := assembleALU(t, `
+        .ORIG x3000
+        LD R1, IE
+        STI R1, KBSRA
+        JSR SUB
+        HALT
+SUB     ADD R2, R2, #1
+        ADD R2, R2, #1
+        RET
+HANDLER LDI R3, KBDRA
+        RTI
+IE      .FILL x4000
+KBSRA   .FILL xFE00
+KBDRA   .FILL xFE02
+        .END
+`)
+	a.Memory[IntVectorTable+IntKeyboard] = 0x3007
+	d := NewDebugger(a)
+
+	for i := 0; i < 4; i++ {
+		d.Step()
+	}
+	assert.Equal(uint16(0x3005), a.PCReg)
+
+	a.pressKey('k')
+	assert.Equal(StopStep, d.Finish())
+	assert.Equal(uint16(0x3003), a.PCReg, "Finish should not stop when the interrupt handler returns")
+	assert.Equal(uint16('k'), a.Reg[3])
+	assert.Equal(uint16(2), a.Reg[2])
+}
+
+func TestDebuggerNextTrap(t *testing.T) {
+	assert := assert.New(t)
+
+	src := `
+        .ORIG x3000
+        LD R0, CHAR
+        OUT
+        ADD R1, R1, #1
+        ADD R1, R1, #1
+        HALT
+CHAR    .FILL x41
+        .END
+`
+	for _, traps := range [][]string{nil, {"native"}} {
+		d := NewDebugger(assembleALU(t, src))
+		assert.NoError(d.ALU.EnableTraps(traps...))
+
+		assert.Equal(StopStep, d.Step())
+		assert.Equal(StopStep, d.Next(), "Traps %v", traps)
+		assert.Equal(uint16(0x3002), d.ALU.PCReg, "Next should step over OUT with traps %v", traps)
+		assert.Equal(uint16(0), d.ALU.Reg[1], "Traps %v", traps)
+	}
+}
+
+func TestDebuggerInput(t *testing.T) {
+	assert := assert.New(t)
+
+	d := NewDebugger(assembleALU(t, ".ORIG x3000\nGETC\nGETC\nADD R1, R0, #0\nHALT\n.END"))
+	d.Input = []byte("a")
+
+	assert.Equal(StopInput, d.Continue())
+	assert.Equal(uint16('a'), d.ALU.Reg[0])
+	assert.Equal(uint16(0x3001), d.ALU.PCReg)
+
+	d.Input = []byte("b")
+	assert.Equal(StopHalted, d.Continue())
+	assert.Equal(uint16('b'), d.ALU.Reg[1])
+}
+
+func TestDebuggerPartialLine(t *testing.T) {
+	assert := assert.New(t)
+
+	d := NewDebugger(assembleALU(t, `
+        .ORIG x3000
+        LEA R0, BUF
+        AND R1, R1, #0
+        ADD R1, R1, #8
+        TRAP x31
+        HALT
+BUF     .BLKW 8
+        .END
+`))
+	assert.NoError(d.ALU.EnableTraps("native", "extra"))
+
+	d.Input = []byte("ab")
+	assert.Equal(StopInput, d.Continue(), "GETS should wait for the rest of the line")
+	assert.Equal(uint16(0x3003), d.ALU.PCReg)
+
+	d.Input = append(d.Input, "c\n"...)
+	assert.Equal(StopHalted, d.Continue())
+	assert.Equal(uint16(3), d.ALU.Reg[1])
+	assert.Equal([]uint16{'a', 'b', 'c', 0}, d.ALU.Memory[0x3005:0x3009])
+}
+
+func TestDebugCLI(t *testing.T) {
+	assert := assert.New(t)
+
+	d := NewDebugger(assembleALU(t, debuggerTestProgram))
+
+	var out bytes.Buffer
+	RunDebugCLI(d, strings.NewReader("b x3004\nc\nregs\nset R0 x7\npc x3003\nx x3003 2\nquit\n"), &out)
+
+	assert.Contains(out.String(), "breakpoint at x3004\nx3004  x1021  ADD R0, R0, #1\n")
+	assert.Contains(out.String(), "R0 x0000  R1 x0000  R2 x0000  R3 x0000\n")
+	assert.Contains(out.String(), "x3003  xF025  HALT\nx3004  x1021  ADD R0, R0, #1\n")
+	assert.Equal(uint16(7), d.ALU.Reg[0])
+	assert.Equal(uint16(0x3003), d.ALU.PCReg)
+}
+
+func TestDebugCLIDevices(t *testing.T) {
+	assert := assert.New(t)
+
+	d := NewDebugger(assembleALU(t, debuggerTestProgram))
+	d.ALU.pressKey('k')
+
+	var out bytes.Buffer
+	RunDebugCLI(d, strings.NewReader("x xFE00 3\nset xFFFE 0\nquit\n"), &out)
+
+	assert.Contains(out.String(), "xFE00  x8000  ")
+	assert.Contains(out.String(), "xFE02  x006B  ")
+	assert.Equal(uint16(0x8000), d.ALU.Peek(KBSR), "Examining KBDR should not consume the key")
+	assert.False(d.ALU.Running, "Clearing the clock enable bit of MCR should stop the machine")
+}
+
+func TestDebugCLISave(t *testing.T) {
+	assert := assert.New(t)
+
+	d := NewDebugger(assembleALU(t, debuggerTestProgram))
+	path := filepath.Join(t.TempDir(), "part.hex")
+
+	var out bytes.Buffer
+	RunDebugCLI(d, strings.NewReader("save "+path+" x3001 x3002\nsave "+path+" x3001 x3000\n"), &out)
+
+	assert.Contains(out.String(), "saved x3001-x3002 to "+path+"\n")
+	assert.Contains(out.String(), "end x3000 is before start x3001\n")
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal("3001\n4802\n4801\n", string(b))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// Instruction is an instruction word split into its fields.
+// All fields are extracted regardless of the opcode; which of them are meaningful depends on Op.
+type Instruction struct {
+	Word uint16 // raw instruction word
+	Op   uint16 // opcode, one of the Op* constants
+
+	DR    uint16 // destination register, source register of stores (bits 11-9)
+	SR1   uint16 // first source register (bits 8-6)
+	BaseR uint16 // base register of LDR, STR, JMP and JSRR (bits 8-6)
+	SR2   uint16 // second source register of ADD and AND (bits 2-0)
+	NZP   uint16 // condition flags of BR (bits 11-9)
+
+	Imm   bool // immediate mode of ADD and AND (bit 5)
+	PCRel bool // PC-relative mode of JSR, as opposed to JSRR (bit 11)
+
+	Imm5       uint16 // sign extended immediate of ADD and AND
+	Offset6    uint16 // sign extended offset of LDR and STR
+	PCOffset9  uint16 // sign extended offset of BR, LD, LDI, LEA, ST and STI
+	PCOffset11 uint16 // sign extended offset of JSR
+	TrapVect   uint16 // trap vector of TRAP
+}
+
+// Decode splits an instruction word into its fields
+func Decode(instr uint16) Instruction {
+	return Instruction{
+		Word:       instr,
+		Op:         subBits(instr, 15, 12),
+		DR:         subBits(instr, 11, 9),
+		SR1:        subBits(instr, 8, 6),
+		BaseR:      subBits(instr, 8, 6),
+		SR2:        subBits(instr, 2, 0),
+		NZP:        subBits(instr, 11, 9),
+		Imm:        subBits(instr, 5, 5) == 1,
+		PCRel:      subBits(instr, 11, 11) == 1,
+		Imm5:       signExtend(subBits(instr, 4, 0), 5),
+		Offset6:    signExtend(subBits(instr, 5, 0), 6),
+		PCOffset9:  signExtend(subBits(instr, 8, 0), 9),
+		PCOffset11: signExtend(subBits(instr, 10, 0), 11),
+		TrapVect:   subBits(instr, 7, 0),
+	}
+}
+
+// Valid reports whether the word is a well-formed instruction rather than data.
+// Branches that can never be taken are considered data.
+func (d Instruction) Valid() bool {
+	switch d.Op {
+	case OpBR:
+		return d.NZP != 0
+	case OpADD, OpAND:
+		return d.Imm || subBits(d.Word, 4, 3) == 0
+	case OpNOT:
+		return subBits(d.Word, 5, 0) == 0x3F
+	case OpJMP:
+		return d.DR == 0 && subBits(d.Word, 5, 0) == 0
+	case OpJSR:
+		return d.PCRel || (subBits(d.Word, 10, 9) == 0 && subBits(d.Word, 5, 0) == 0)
+	case OpRTI:
+		return subBits(d.Word, 11, 0) == 0
+	case OpRES:
+		return false
+	case OpTRAP:
+		return subBits(d.Word, 11, 8) == 0
+	}
+	return true
+}
+
+// trapNames maps the trap vectors to their mnemonics
+var trapNames = map[uint16]string{
+	TrapGETC:  "GETC",
+	TrapOUT:   "OUT",
+	TrapPUTS:  "PUTS",
+	TrapIN:    "IN",
+	TrapPUTSP: "PUTSP",
+	TrapHALT:  "HALT",
+}
+
+// condString returns condition flags as the letters n, z and p
+func condString(cond uint16) string {
+	s := ""
+	for i, c := range "nzp" {
+		if cond&(CondNEG>>uint(i)) != 0 {
+			s += string(c)
+		}
+	}
+	return s
+}
+
+// Disassemble returns the assembly text of the word located at addr.
+// PC-relative operands are resolved to absolute addresses, data is shown as .FILL.
+func Disassemble(instr, addr uint16) string {
+	return DisassembleSym(instr, addr, nil)
+}
+
+// DisassembleSym is Disassemble showing PC-relative operands as labels where syms has one
+func DisassembleSym(instr, addr uint16, syms *SymbolTable) string {
+	d := Decode(instr)
+	if !d.Valid() {
+		return fmt.Sprintf(".FILL x%04X", instr)
+	}
+
+	target := addr + 1 + d.PCOffset9
+
+	switch d.Op {
+	case OpBR:
+		return fmt.Sprintf("BR%s %s", condString(d.NZP), syms.Target(target))
+	case OpADD, OpAND:
+		name := map[uint16]string{OpADD: "ADD", OpAND: "AND"}[d.Op]
+		if d.Imm {
+			return fmt.Sprintf("%s R%d, R%d, #%d", name, d.DR, d.SR1, int16(d.Imm5))
+		}
+		return fmt.Sprintf("%s R%d, R%d, R%d", name, d.DR, d.SR1, d.SR2)
+	case OpNOT:
+		return fmt.Sprintf("NOT R%d, R%d", d.DR, d.SR1)
+	case OpJMP:
+		if d.BaseR == 7 {
+			return "RET"
+		}
+		return fmt.Sprintf("JMP R%d", d.BaseR)
+	case OpJSR:
+		if d.PCRel {
+			return fmt.Sprintf("JSR %s", syms.Target(addr+1+d.PCOffset11))
+		}
+		return fmt.Sprintf("JSRR R%d", d.BaseR)
+	case OpLD, OpLDI, OpLEA, OpST, OpSTI:
+		name := map[uint16]string{OpLD: "LD", OpLDI: "LDI", OpLEA: "LEA", OpST: "ST", OpSTI: "STI"}[d.Op]
+		return fmt.Sprintf("%s R%d, %s", name, d.DR, syms.Target(target))
+	case OpLDR, OpSTR:
+		name := map[uint16]string{OpLDR: "LDR", OpSTR: "STR"}[d.Op]
+		return fmt.Sprintf("%s R%d, R%d, #%d", name, d.DR, d.BaseR, int16(d.Offset6))
+	case OpRTI:
+		return "RTI"
+	}
+
+	// OpTRAP
+	if name, ok := trapNames[d.TrapVect]; ok {
+		return name
+	}
+	return fmt.Sprintf("TRAP x%02X", d.TrapVect)
+}
+
+// DisassembleRange writes one line per word from start to end inclusively
+func DisassembleRange(w io.Writer, memory *[65536]uint16, start, end uint16) error {
+	return DisassembleRangeSym(w, memory, nil, start, end)
+}
+
+// DisassembleRangeSym is DisassembleRange using the labels of syms, each on a line of its own
+func DisassembleRangeSym(w io.Writer, memory *[65536]uint16, syms *SymbolTable, start, end uint16) error {
+	return disassembleRange(w, func(addr uint16) uint16 { return memory[addr] }, syms, start, end)
+}
+
+// disassembleRange is DisassembleRangeSym reading the words with read
+func disassembleRange(w io.Writer, read func(addr uint16) uint16, syms *SymbolTable, start, end uint16) error {
+	for addr := int(start); addr <= int(end); addr++ {
+		if label, ok := syms.Label(uint16(addr)); ok {
+			if _, err := fmt.Fprintf(w, "%s:\n", label); err != nil {
+				return err
+			}
+		}
+		instr := read(uint16(addr))
+		if _, err := fmt.Fprintf(w, "x%04X  x%04X  %s\n", addr, instr, DisassembleSym(instr, uint16(addr), syms)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
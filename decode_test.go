@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	assert := assert.New(t)
+
+	d := Decode(0x12BF) // ADD R1, R2, #-1
+	assert.Equal(OpADD, d.Op)
+	assert.Equal(uint16(1), d.DR)
+	assert.Equal(uint16(2), d.SR1)
+	assert.True(d.Imm)
+	assert.Equal(uint16(0xFFFF), d.Imm5)
+
+	d = Decode(0x4FFF) // JSR #-1
+	assert.Equal(OpJSR, d.Op)
+	assert.True(d.PCRel)
+	assert.Equal(uint16(0xFFFF), d.PCOffset11)
+
+	d = Decode(0x65BE) // LDR R2, R6, #-2
+	assert.Equal(uint16(6), d.BaseR)
+	assert.Equal(uint16(0xFFFE), d.Offset6)
+
+	d = Decode(0xF025)
+	assert.Equal(OpTRAP, d.Op)
+	assert.Equal(uint16(TrapHALT), d.TrapVect)
+}
+
+func TestDisassemble(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		instr uint16
+		addr  uint16
+
+		expected string
+	}{
+		{instr: 0x1283, addr: 0x3000, expected: "ADD R1, R2, R3"},
+		{instr: 0x12BF, addr: 0x3000, expected: "ADD R1, R2, #-1"},
+		{instr: 0x5020, addr: 0x3000, expected: "AND R0, R0, #0"},
+		{instr: 0x997F, addr: 0x3000, expected: "NOT R4, R5"},
+		{instr: 0x0DFB, addr: 0x3004, expected: "BRnz x3000"},
+		{instr: 0x0E12, addr: 0x3005, expected: "BRnzp x3018"},
+		{instr: 0xC0C0, addr: 0x3000, expected: "JMP R3"},
+		{instr: 0xC1C0, addr: 0x3000, expected: "RET"},
+		{instr: 0x480A, addr: 0x3008, expected: "JSR x3013"},
+		{instr: 0x4080, addr: 0x3000, expected: "JSRR R2"},
+		{instr: 0x200E, addr: 0x300A, expected: "LD R0, x3019"},
+		{instr: 0xA20D, addr: 0x300B, expected: "LDI R1, x3019"},
+		{instr: 0x65BE, addr: 0x3000, expected: "LDR R2, R6, #-2"},
+		{instr: 0xE60F, addr: 0x300D, expected: "LEA R3, x301D"},
+		{instr: 0x300A, addr: 0x300E, expected: "ST R0, x3019"},
+		{instr: 0xB209, addr: 0x300F, expected: "STI R1, x3019"},
+		{instr: 0x7585, addr: 0x3000, expected: "STR R2, R6, #5"},
+		{instr: 0x8000, addr: 0x3000, expected: "RTI"},
+		{instr: 0xF025, addr: 0x3000, expected: "HALT"},
+		{instr: 0xF030, addr: 0x3000, expected: "TRAP x30"},
+		{instr: 0x0000, addr: 0x3000, expected: ".FILL x0000"},
+		{instr: 0xD123, addr: 0x3000, expected: ".FILL xD123"},
+		{instr: 0x1098, addr: 0x3000, expected: ".FILL x1098"},
+		{instr: 0xFF25, addr: 0x3000, expected: ".FILL xFF25"},
+	}
+
+	for _, testData := range tests {
+		assert.Equal(testData.expected, Disassemble(testData.instr, testData.addr), "Should be equal for x%04X", testData.instr)
+	}
+}
+
+func TestDisassembleRange(t *testing.T) {
+	assert := assert.New(t)
+
+	var memory [65536]uint16
+	memory[0x3000] = 0x5020
+	memory[0x3001] = 0xF025
+
+	var buf bytes.Buffer
+	assert.NoError(DisassembleRange(&buf, &memory, 0x3000, 0x3002))
+	assert.Equal("x3000  x5020  AND R0, R0, #0\n"+
+		"x3001  xF025  HALT\n"+
+		"x3002  x0000  .FILL x0000\n", buf.String())
+}
@@ -0,0 +1,44 @@
+package main
+
+import "fmt"
+
+// displayDevice returns the bus device for DSR and DDR. A character written to DDR goes to
+// the console output; the ready bit of DSR is cleared until the next instruction starts.
+func (a *ALU) displayDevice() *Device {
+	ready := true
+	return &Device{
+		Read: func(addr uint16) uint16 {
+			if addr == DSR && ready {
+				return 0x8000
+			}
+			return 0
+		},
+		Write: func(addr, val uint16) {
+			if addr == DDR && ready {
+				fmt.Fprintf(a.output(), "%c", rune(val&0xFF))
+				ready = false
+			}
+		},
+		Tick: func() {
+			ready = true
+		},
+	}
+}
+
+// mcrDevice returns the bus device for MCR. Its bit 15 is the clock enable bit, which
+// mirrors Running: clearing it stops the machine.
+func (a *ALU) mcrDevice() *Device {
+	var mcr uint16
+	return &Device{
+		Read: func(uint16) uint16 {
+			if a.Running {
+				return mcr | 0x8000
+			}
+			return mcr
+		},
+		Write: func(_, val uint16) {
+			mcr = val & 0x7FFF
+			a.Running = val&0x8000 != 0
+		},
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Format is an object file format
+type Format int
+
+const (
+	FormatRaw      Format = iota // big-endian origin followed by the words, as written by the asm command
+	FormatLC3Tools               // lc3tools object file: a magic header followed by entries flagging origins
+	FormatHex                    // text, one word of 4 hexadecimal digits per line, the origin first
+	FormatBin                    // text, one word of 16 binary digits per line, the origin first
+)
+
+var formatNames = []string{
+	FormatRaw:      "raw",
+	FormatLC3Tools: "lc3tools",
+	FormatHex:      "hex",
+	FormatBin:      "bin",
+}
+
+func (f Format) String() string {
+	return formatNames[f]
+}
+
+// ParseFormat returns the format with the given name
+func ParseFormat(name string) (Format, error) {
+	for f, n := range formatNames {
+		if n == name {
+			return Format(f), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown format %q, available: %s", name, strings.Join(formatNames, ", "))
+}
+
+// lc3toolsHeader starts every lc3tools object file: the magic number and the version
+const lc3toolsHeader = "\x1c\x30\x15\xc0\x01\x01"
+
+// DetectFormat guesses the format of an object file from its contents
+func DetectFormat(b []byte) Format {
+	switch {
+	case bytes.HasPrefix(b, []byte(lc3toolsHeader)):
+		return FormatLC3Tools
+	case len(b) == 0:
+		return FormatRaw
+	}
+	if _, err := parseText(b, 2); err == nil {
+		return FormatBin
+	}
+	if _, err := parseText(b, 16); err == nil {
+		return FormatHex
+	}
+	return FormatRaw
+}
+
+// formatOf returns the format of an object file: text formats by their .hex and .bin
+// extension, others by their contents
+func formatOf(path string, b []byte) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".hex":
+		return FormatHex
+	case ".bin":
+		return FormatBin
+	}
+	return DetectFormat(b)
+}
+
+// outputFormat returns the named format, or the format of the .hex and .bin extensions and raw otherwise
+func outputFormat(path, name string) (Format, error) {
+	if name != "" {
+		return ParseFormat(name)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".hex":
+		return FormatHex, nil
+	case ".bin":
+		return FormatBin, nil
+	}
+	return FormatRaw, nil
+}
+
+// parseLC3Tools reads an lc3tools object file. Each entry after the header holds a little-endian
+// word, a flag telling whether the word is an origin and the source line the word came from.
+func parseLC3Tools(b []byte) ([]chunk, error) {
+	if !bytes.HasPrefix(b, []byte(lc3toolsHeader)) {
+		return nil, fmt.Errorf("missing lc3tools header")
+	}
+	b = b[len(lc3toolsHeader):]
+
+	var chunks []chunk
+	for len(b) > 0 {
+		if len(b) < 7 {
+			return nil, fmt.Errorf("truncated object file: incomplete entry")
+		}
+		value, orig, n := binary.LittleEndian.Uint16(b), b[2] != 0, binary.LittleEndian.Uint32(b[3:])
+		b = b[7:]
+		if uint32(len(b)) < n {
+			return nil, fmt.Errorf("truncated object file: incomplete source line")
+		}
+		b = b[n:]
+
+		if orig {
+			chunks = append(chunks, chunk{Segment: Segment{Origin: value}})
+			continue
+		}
+		if len(chunks) == 0 {
+			return nil, fmt.Errorf("word x%04X before the first origin", value)
+		}
+		c := &chunks[len(chunks)-1]
+		if c.End() == 0x10000 {
+			return nil, fmt.Errorf("words at x%04X run past xFFFF", c.Origin)
+		}
+		c.words = append(c.words, value)
+		c.Length++
+	}
+
+	// origins without words take no memory
+	var nonEmpty []chunk
+	for _, c := range chunks {
+		if c.Length > 0 {
+			nonEmpty = append(nonEmpty, c)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return nil, fmt.Errorf("empty object file")
+	}
+	return nonEmpty, nil
+}
+
+// parseText reads a text object file with words in the given base, 16 or 2. Blank lines and
+// comments starting with a semicolon are ignored; hexadecimal words may be prefixed with x or 0x.
+func parseText(b []byte, base int) ([]chunk, error) {
+	digits := 4
+	if base == 2 {
+		digits = 16
+	}
+
+	var words []uint16
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if base == 16 {
+			line = strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(line, "0x"), "x"), "X")
+		}
+
+		v, err := strconv.ParseUint(line, base, 16)
+		if err != nil || len(line) != digits {
+			return nil, fmt.Errorf("line %d: expected a word of %d digits in base %d, found %q", n, digits, base, line)
+		}
+		words = append(words, uint16(v))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return originChunk(words)
+}
+
+// WriteImage writes the segments of memory as an object file. Only the lc3tools format
+// holds several segments.
+func WriteImage(w io.Writer, format Format, memory *[65536]uint16, segments []Segment) error {
+	if len(segments) == 0 {
+		return fmt.Errorf("nothing to write")
+	}
+	if len(segments) > 1 && format != FormatLC3Tools {
+		return fmt.Errorf("the %s format holds a single segment, not %d", format, len(segments))
+	}
+
+	bw := bufio.NewWriter(w)
+	if format == FormatLC3Tools {
+		bw.WriteString(lc3toolsHeader)
+	}
+	for _, s := range segments {
+		if s.Length <= 0 || s.End() > 0x10000 {
+			return fmt.Errorf("invalid segment of %d words at x%04X", s.Length, s.Origin)
+		}
+		writeWord(bw, format, s.Origin, true)
+		for _, word := range memory[s.Origin:s.End()] {
+			writeWord(bw, format, word, false)
+		}
+	}
+	return bw.Flush()
+}
+
+// writeWord writes a word in the given format, orig flagging an origin for lc3tools
+func writeWord(w *bufio.Writer, format Format, word uint16, orig bool) {
+	switch format {
+	case FormatLC3Tools:
+		entry := make([]byte, 7)
+		binary.LittleEndian.PutUint16(entry, word)
+		if orig {
+			entry[2] = 1
+		}
+		w.Write(entry)
+	case FormatHex:
+		fmt.Fprintf(w, "%04X\n", word)
+	case FormatBin:
+		fmt.Fprintf(w, "%016b\n", word)
+	default:
+		w.WriteByte(byte(word >> 8))
+		w.WriteByte(byte(word))
+	}
+}
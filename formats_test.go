@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatsRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	var memory [65536]uint16
+	memory[0x3000] = 0x5020
+	memory[0x3001] = 0xF025
+	segments := []Segment{{0x3000, 2}}
+
+	expected := map[Format]string{
+		FormatRaw:      "\x30\x00\x50\x20\xF0\x25",
+		FormatLC3Tools: lc3toolsHeader + "\x00\x30\x01\x00\x00\x00\x00" + "\x20\x50\x00\x00\x00\x00\x00" + "\x25\xF0\x00\x00\x00\x00\x00",
+		FormatHex:      "3000\n5020\nF025\n",
+		FormatBin:      "0011000000000000\n0101000000100000\n1111000000100101\n",
+	}
+
+	for format, contents := range expected {
+		var buf bytes.Buffer
+		assert.NoError(WriteImage(&buf, format, &memory, segments), format.String())
+		assert.Equal(contents, buf.String(), "Contents of %s", format)
+		assert.Equal(format, DetectFormat(buf.Bytes()), "Detected format of %s", format)
+
+		var loaded [65536]uint16
+		loadedSegments, err := LoadBytes(&loaded, buf.Bytes())
+		assert.NoError(err, format.String())
+		assert.Equal(segments, loadedSegments, "Segments of %s", format)
+		assert.Equal(memory, loaded, "Memory of %s", format)
+	}
+}
+
+func TestLoadLC3Tools(t *testing.T) {
+	assert := assert.New(t)
+
+	obj := lc3toolsHeader +
+		"\x00\x30\x01\x0b\x00\x00\x00.ORIG x3000" +
+		"\x25\xF0\x00\x04\x00\x00\x00HALT" +
+		"\x00\x40\x01\x00\x00\x00\x00" +
+		"\x34\x12\x00\x00\x00\x00\x00" +
+		"\x78\x56\x00\x00\x00\x00\x00"
+
+	var memory [65536]uint16
+	segments, err := LoadBytes(&memory, []byte(obj))
+	assert.NoError(err)
+	assert.Equal([]Segment{{0x3000, 1}, {0x4000, 2}}, segments)
+	assert.Equal(uint16(0xF025), memory[0x3000])
+	assert.Equal([]uint16{0x1234, 0x5678}, memory[0x4000:0x4002])
+
+	_, err = LoadBytes(&memory, []byte(obj[:len(obj)-3]))
+	assert.EqualError(err, "truncated object file: incomplete entry")
+	_, err = LoadBytes(&memory, []byte(lc3toolsHeader+"\x25\xF0\x00\x00\x00\x00\x00"))
+	assert.EqualError(err, "word xF025 before the first origin")
+
+	var buf bytes.Buffer
+	assert.NoError(WriteImage(&buf, FormatLC3Tools, &memory, segments))
+	var loaded [65536]uint16
+	loadedSegments, err := LoadBytes(&loaded, buf.Bytes())
+	assert.NoError(err)
+	assert.Equal(segments, loadedSegments)
+
+	assert.EqualError(WriteImage(&buf, FormatHex, &memory, segments), "the hex format holds a single segment, not 2")
+}
+
+func TestLoadText(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	hex := filepath.Join(dir, "prog.hex")
+	assert.NoError(ioutil.WriteFile(hex, []byte("; program\nx3000\n\n0x5020 ; AND R0, R0, #0\r\nf025\n"), 0644))
+	bin := filepath.Join(dir, "prog.bin")
+	assert.NoError(ioutil.WriteFile(bin, []byte("0011000000000000\n010100000010000\n"), 0644))
+
+	var memory [65536]uint16
+	segments, err := Load(&memory, hex)
+	assert.NoError(err)
+	assert.Equal([]Segment{{0x3000, 2}}, segments)
+	assert.Equal([]uint16{0x5020, 0xF025}, memory[0x3000:0x3002])
+
+	_, err = Load(&memory, bin)
+	assert.EqualError(err, bin+`: line 2: expected a word of 16 digits in base 2, found "010100000010000"`)
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The stub presents memory to the client as a byte-addressed, little-endian space:
+// word w of LC-3 memory occupies bytes 2w and 2w+1. The pc register holds the byte address of PCReg.
+//
+// Registers in the order of the g packet: r0-r7 (16 bit), pc (32 bit), psr (16 bit).
+const (
+	gdbRegPC   = 8
+	gdbRegPSR  = 9
+	gdbNumRegs = 10
+)
+
+const gdbTargetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.lc3.core">
+    <reg name="r0" bitsize="16" type="int16" regnum="0"/>
+    <reg name="r1" bitsize="16" type="int16"/>
+    <reg name="r2" bitsize="16" type="int16"/>
+    <reg name="r3" bitsize="16" type="int16"/>
+    <reg name="r4" bitsize="16" type="int16"/>
+    <reg name="r5" bitsize="16" type="int16"/>
+    <reg name="r6" bitsize="16" type="data_ptr"/>
+    <reg name="r7" bitsize="16" type="code_ptr"/>
+    <reg name="pc" bitsize="32" type="code_ptr"/>
+    <reg name="psr" bitsize="16" type="int16"/>
+  </feature>
+</target>
+`
+
+// gdbStub serves the GDB Remote Serial Protocol for a debugger over a single connection
+type gdbStub struct {
+	*Debugger
+
+	w       io.Writer
+	wmu     sync.Mutex
+	packets chan string
+	noAck   int32 // set once the client switched off acknowledgments
+}
+
+// ServeGDB answers GDB Remote Serial Protocol requests on conn until the client
+// detaches or kills the target, the connection is closed, or the program halts.
+func ServeGDB(d *Debugger, conn io.ReadWriter) error {
+	g := &gdbStub{
+		Debugger: d,
+		w:        conn,
+		packets:  make(chan string),
+	}
+	go g.readPackets(bufio.NewReader(conn))
+
+	for packet := range g.packets {
+		reply, done := g.handle(packet)
+		if packet == "k" {
+			// kill has no reply
+			return nil
+		}
+		if err := g.send(reply); err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+	return nil
+}
+
+// readPackets acknowledges and forwards incoming packets. An interrupt byte pauses the debugger.
+func (g *gdbStub) readPackets(r *bufio.Reader) {
+	defer close(g.packets)
+
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+
+		switch c {
+		case 0x03:
+			g.Pause()
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+
+			sum := make([]byte, 2)
+			if _, err := io.ReadFull(r, sum); err != nil {
+				return
+			}
+
+			if want, err := strconv.ParseUint(string(sum), 16, 8); err != nil || byte(want) != gdbChecksum(data) {
+				g.write("-")
+				continue
+			}
+			if atomic.LoadInt32(&g.noAck) == 0 {
+				g.write("+")
+			}
+			g.packets <- data
+		}
+	}
+}
+
+func gdbChecksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+func (g *gdbStub) write(s string) error {
+	g.wmu.Lock()
+	defer g.wmu.Unlock()
+	_, err := io.WriteString(g.w, s)
+	return err
+}
+
+func (g *gdbStub) send(data string) error {
+	return g.write(fmt.Sprintf("$%s#%02x", data, gdbChecksum(data)))
+}
+
+// handle returns the reply to a packet and whether the session is over
+func (g *gdbStub) handle(packet string) (string, bool) {
+	if packet == "" {
+		return "", false
+	}
+	args := packet[1:]
+
+	switch packet[0] {
+	case '?':
+		return g.stopReply(StopStep), false
+	case 'g':
+		var b strings.Builder
+		for i := 0; i < gdbNumRegs; i++ {
+			b.WriteString(g.readRegister(i))
+		}
+		return b.String(), false
+	case 'G':
+		for i := 0; i < gdbNumRegs && args != ""; i++ {
+			n := 4
+			if i == gdbRegPC {
+				n = 8
+			}
+			if len(args) < n || !g.writeRegister(i, args[:n]) {
+				return "E01", false
+			}
+			args = args[n:]
+		}
+		return "OK", false
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || n >= gdbNumRegs {
+			return "E01", false
+		}
+		return g.readRegister(int(n)), false
+	case 'P':
+		parts := strings.SplitN(args, "=", 2)
+		n, err := strconv.ParseUint(parts[0], 16, 8)
+		if err != nil || n >= gdbNumRegs || len(parts) != 2 || !g.writeRegister(int(n), parts[1]) {
+			return "E01", false
+		}
+		return "OK", false
+	case 'm':
+		addr, length, ok := parseAddrLength(args)
+		if !ok || addr >= 0x20000 || length > 0x20000-addr {
+			return "E01", false
+		}
+		b := make([]byte, length)
+		for i := range b {
+			b[i] = g.readByte(int(addr) + i)
+		}
+		return hex.EncodeToString(b), false
+	case 'M':
+		parts := strings.SplitN(args, ":", 2)
+		addr, length, ok := parseAddrLength(parts[0])
+		if !ok || len(parts) != 2 || addr >= 0x20000 || length > 0x20000-addr {
+			return "E01", false
+		}
+		b, err := hex.DecodeString(parts[1])
+		if err != nil || uint32(len(b)) != length {
+			return "E01", false
+		}
+		g.writeBytes(int(addr), b)
+		return "OK", false
+	case 'Z', 'z':
+		fields := strings.Split(args, ",")
+		if len(fields) != 3 || (fields[0] != "0" && fields[0] != "1") {
+			return "", false
+		}
+		addr, err := strconv.ParseUint(fields[1], 16, 32)
+		if err != nil || addr >= 0x20000 {
+			return "E01", false
+		}
+		if packet[0] == 'Z' {
+			g.Breakpoints[uint16(addr/2)] = true
+		} else {
+			delete(g.Breakpoints, uint16(addr/2))
+		}
+		return "OK", false
+	case 'c', 's':
+		if args != "" {
+			addr, err := strconv.ParseUint(args, 16, 32)
+			if err != nil || addr >= 0x20000 {
+				return "E01", false
+			}
+			g.ALU.PCReg = uint16(addr / 2)
+		}
+		reason := g.resume(packet[0] == 's')
+		return g.stopReply(reason), reason == StopHalted
+	case 'H', 'T':
+		return "OK", false
+	case 'D':
+		return "OK", true
+	case 'k':
+		g.ALU.Running = false
+		return "", true
+	case 'q', 'Q':
+		return g.query(packet), false
+	}
+
+	return "", false
+}
+
+// query answers the general query packets the stub supports
+func (g *gdbStub) query(packet string) string {
+	switch {
+	case strings.HasPrefix(packet, "qSupported"):
+		return "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+"
+	case packet == "QStartNoAckMode":
+		atomic.StoreInt32(&g.noAck, 1)
+		return "OK"
+	case packet == "qAttached":
+		return "1"
+	case packet == "qC":
+		return "QC1"
+	case packet == "qfThreadInfo":
+		return "m1"
+	case packet == "qsThreadInfo":
+		return "l"
+	case strings.HasPrefix(packet, "qXfer:features:read:target.xml:"):
+		offset, length, ok := parseAddrLength(strings.TrimPrefix(packet, "qXfer:features:read:target.xml:"))
+		if !ok {
+			return "E01"
+		}
+		if int(offset) >= len(gdbTargetXML) {
+			return "l"
+		}
+		chunk := gdbTargetXML[offset:]
+		if uint32(len(chunk)) > length {
+			return "m" + chunk[:length]
+		}
+		return "l" + chunk
+	}
+	return ""
+}
+
+// resume steps or continues, waiting for keyboard input whenever the program needs it
+func (g *gdbStub) resume(step bool) StopReason {
+	for {
+		var reason StopReason
+		if step {
+			reason = g.Step()
+		} else {
+			reason = g.Continue()
+		}
+
+		if reason != StopInput {
+			return reason
+		}
+		if g.pausePending() {
+			return StopPaused
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// stopReply reports the state of the target: exited with the exit code of the machine
+// once halted, SIGINT when paused and SIGTRAP otherwise
+func (g *gdbStub) stopReply(reason StopReason) string {
+	switch {
+	case !g.ALU.Running:
+		return fmt.Sprintf("W%02x", byte(g.ALU.ExitCode()))
+	case reason == StopPaused:
+		return "S02"
+	}
+	return "S05"
+}
+
+func (g *gdbStub) readRegister(n int) string {
+	switch n {
+	case gdbRegPC:
+		pc := uint32(g.ALU.PCReg) * 2
+		return fmt.Sprintf("%02x%02x%02x%02x", byte(pc), byte(pc>>8), byte(pc>>16), byte(pc>>24))
+	case gdbRegPSR:
+		psr := g.ALU.PSR()
+		return fmt.Sprintf("%02x%02x", byte(psr), byte(psr>>8))
+	}
+	return fmt.Sprintf("%02x%02x", byte(g.ALU.Reg[n]), byte(g.ALU.Reg[n]>>8))
+}
+
+func (g *gdbStub) writeRegister(n int, value string) bool {
+	b, err := hex.DecodeString(value)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case n == gdbRegPC && len(b) == 4:
+		pc := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+		g.ALU.PCReg = uint16(pc / 2)
+	case n == gdbRegPSR && len(b) == 2:
+		g.ALU.SetPSR(uint16(b[0]) | uint16(b[1])<<8)
+	case n < 8 && len(b) == 2:
+		g.ALU.Reg[n] = uint16(b[0]) | uint16(b[1])<<8
+	default:
+		return false
+	}
+	return true
+}
+
+// parseAddrLength parses the "addr,length" argument of memory packets
+func parseAddrLength(s string) (uint32, uint32, bool) {
+	parts := strings.SplitN(s, ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	addr, err1 := strconv.ParseUint(parts[0], 16, 32)
+	length, err2 := strconv.ParseUint(parts[1], 16, 32)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	return uint32(addr), uint32(length), true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// gdbClient exchanges packets with a stub over an in-memory connection
+type gdbClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *gdbClient) request(data string) string {
+	fmt.Fprintf(c.conn, "$%s#%02x", data, gdbChecksum(data))
+
+	ack, err := c.r.ReadByte()
+	if err != nil || ack != '+' {
+		c.t.Fatalf("no acknowledgment for %s", data)
+	}
+	if data == "k" {
+		return ""
+	}
+
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+	reply, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	io.ReadFull(c.r, make([]byte, 2))
+	return reply[:len(reply)-1]
+}
+
+func TestGDBStub(t *testing.T) {
+	assert := assert.New(t)
+
+	a := assembleALU(t, debuggerTestProgram)
+	a.Reg[1] = 0x1234
+
+	server, client := net.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- ServeGDB(NewDebugger(a), server)
+	}()
+
+	c := &gdbClient{t: t, conn: client, r: bufio.NewReader(client)}
+
+	assert.Equal("S05", c.request("?"))
+	assert.Equal("0000"+"3412"+"0000000000000000"+"0030"+"0000"+"00600000"+"0000", c.request("g"))
+	assert.Equal("00600000", c.request("p8"))
+	assert.Equal("OK", c.request("P2=ffff"))
+	assert.Equal(uint16(0xFFFF), a.Reg[2])
+
+	// x3001 is JSR INC
+	assert.Equal("0248", c.request("m6002,2"))
+	assert.Equal("OK", c.request("M7000,2:efbe"))
+	assert.Equal(uint16(0xBEEF), a.Memory[0x3800])
+	assert.Equal("OK", c.request("M7001,2:1122"))
+	assert.Equal([]uint16{0x11EF, 0x0022}, a.Memory[0x3800:0x3802])
+	a.pressKey('k')
+	assert.Equal("008000006b00", c.request("m1fc00,6"), "Device registers should be read through the bus")
+	assert.Equal("E01", c.request("mffffffff,2"))
+	assert.Equal("E01", c.request("m1fffe,4"))
+	assert.Equal("E01", c.request("Mffffffff,2:0000"))
+
+	assert.Equal("OK", c.request("Z0,6008,2"))
+	assert.Equal("S05", c.request("c"))
+	assert.Equal(uint16(0x3004), a.PCReg)
+
+	assert.Equal("S05", c.request("s"))
+	assert.Equal(uint16(0x3005), a.PCReg)
+
+	assert.Equal("OK", c.request("z0,6008,2"))
+	assert.Equal("W00", c.request("c"))
+	assert.NoError(<-done)
+}
+
+func TestGDBStubTargetXML(t *testing.T) {
+	assert := assert.New(t)
+
+	g := &gdbStub{Debugger: NewDebugger(NewALU())}
+
+	assert.Equal("m<?xml", g.query("qXfer:features:read:target.xml:0,5"))
+	assert.Equal("l"+gdbTargetXML[5:], g.query("qXfer:features:read:target.xml:5,1000"))
+}
@@ -0,0 +1,131 @@
+package main
+
+import "fmt"
+
+// Exception and interrupt vectors, relative to IntVectorTable
+const (
+	ExcPrivilege     = 0x00 // privilege mode violation
+	ExcIllegalOpcode = 0x01 // illegal opcode
+	ExcAccessControl = 0x02 // access control violation
+
+	IntKeyboard = 0x80 // keyboard interrupt
+)
+
+// KeyboardPriority is the priority level of keyboard interrupts
+const KeyboardPriority = 4
+
+// PSR returns the processor status register: privilege mode in bit 15,
+// priority level in bits 10 to 8 and condition codes in bits 2 to 0
+func (a *ALU) PSR() uint16 {
+	psr := a.Priority<<8 | a.CondReg&0x7
+	if a.User {
+		psr |= 0x8000
+	}
+	return psr
+}
+
+// SetPSR sets privilege mode, priority level and condition codes from a processor status register value.
+// It does not switch stacks.
+func (a *ALU) SetPSR(psr uint16) {
+	a.User = psr&0x8000 != 0
+	a.Priority = subBits(psr, 10, 8)
+	a.CondReg = psr & 0x7
+}
+
+// psrDevice returns the bus device for PSR
+func (a *ALU) psrDevice() *Device {
+	return &Device{
+		Read: func(uint16) uint16 {
+			return a.PSR()
+		},
+		Write: func(_, val uint16) {
+			a.SetPSR(val)
+		},
+	}
+}
+
+// push pushes a word onto the stack pointed to by R6
+func (a *ALU) push(val uint16) {
+	a.Reg[6]--
+	a.Write(a.Reg[6], val)
+}
+
+// pop pops a word from the stack pointed to by R6
+func (a *ALU) pop() uint16 {
+	val := a.Read(a.Reg[6])
+	a.Reg[6]++
+	return val
+}
+
+// vectorNames describes the exceptions and interrupts
+var vectorNames = map[uint16]string{
+	ExcPrivilege:     "privilege mode violation",
+	ExcIllegalOpcode: "illegal opcode",
+	ExcAccessControl: "access control violation",
+	IntKeyboard:      "keyboard interrupt",
+}
+
+// Exception is the error a machine stops with when an exception or interrupt
+// occurs and the interrupt vector table holds no handler for it
+type Exception struct {
+	Vector uint16 // vector, relative to IntVectorTable
+	PC     uint16 // address of the instruction being executed
+	Instr  uint16 // the instruction word at PC
+
+	Symbols *SymbolTable // labels to show in the message, may be nil
+}
+
+func (e *Exception) Error() string {
+	name, ok := vectorNames[e.Vector]
+	if !ok {
+		name = fmt.Sprintf("interrupt x%02X", e.Vector)
+	}
+	return fmt.Sprintf("%s at %s (%s): no handler installed at x%04X",
+		name, e.Symbols.Describe(e.PC), DisassembleSym(e.Instr, e.PC, e.Symbols), IntVectorTable+e.Vector)
+}
+
+// interrupt switches to supervisor mode and the supervisor stack, pushes PSR and PC,
+// sets the priority level and continues at the address found in the interrupt vector table.
+// If the table has no entry for the vector the machine stops with an Exception.
+func (a *ALU) interrupt(vector, priority uint16) {
+	handler := a.Read(IntVectorTable + vector)
+	if handler == 0 {
+		a.Running = false
+		a.Err = &Exception{Vector: vector, PC: a.instrAddr, Instr: a.Peek(a.instrAddr), Symbols: a.Symbols}
+		return
+	}
+
+	a.enterSupervisor()
+	a.Priority = priority
+	a.PCReg = handler
+	a.entered++
+}
+
+// enterSupervisor switches to supervisor mode and the supervisor stack and pushes PSR and PC,
+// the state RTI returns to
+func (a *ALU) enterSupervisor() {
+	psr := a.PSR()
+	if a.User {
+		a.SavedUSP = a.Reg[6]
+		a.Reg[6] = a.SavedSSP
+		a.User = false
+	}
+
+	a.push(psr)
+	a.push(a.PCReg)
+}
+
+// raise starts the handler of an exception, which runs at the current priority level
+func (a *ALU) raise(vector uint16) {
+	a.interrupt(vector, a.Priority)
+}
+
+// accessible reports whether the program may access addr. In user mode, system space and
+// device registers are off limits and accessing them raises an access control violation.
+func (a *ALU) accessible(addr uint16) bool {
+	if a.User && (addr < UserSpace || addr >= DeviceSpace) {
+		a.raise(ExcAccessControl)
+		return false
+	}
+	return true
+}
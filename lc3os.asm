@@ -0,0 +1,426 @@
+; Default LC-3 operating system, loaded into every new machine.
+;
+; x0000-x00FF  trap vector table
+; x0100-x01FF  interrupt vector table, left empty so that unhandled
+;              exceptions and interrupts are reported by the emulator
+; x0200-       service routines
+;
+; Trap routines run in supervisor mode, entered with PSR and PC on the
+; supervisor stack and R7 holding the return address. They return with RTI
+; and preserve every register except R0 where it carries a result. HALT
+; stops the machine with R0 holding the MCR value it wrote.
+
+        .ORIG x0000
+
+        .FILL BAD_TRAP    ; x00
+        .FILL BAD_TRAP    ; x01
+        .FILL BAD_TRAP    ; x02
+        .FILL BAD_TRAP    ; x03
+        .FILL BAD_TRAP    ; x04
+        .FILL BAD_TRAP    ; x05
+        .FILL BAD_TRAP    ; x06
+        .FILL BAD_TRAP    ; x07
+        .FILL BAD_TRAP    ; x08
+        .FILL BAD_TRAP    ; x09
+        .FILL BAD_TRAP    ; x0A
+        .FILL BAD_TRAP    ; x0B
+        .FILL BAD_TRAP    ; x0C
+        .FILL BAD_TRAP    ; x0D
+        .FILL BAD_TRAP    ; x0E
+        .FILL BAD_TRAP    ; x0F
+        .FILL BAD_TRAP    ; x10
+        .FILL BAD_TRAP    ; x11
+        .FILL BAD_TRAP    ; x12
+        .FILL BAD_TRAP    ; x13
+        .FILL BAD_TRAP    ; x14
+        .FILL BAD_TRAP    ; x15
+        .FILL BAD_TRAP    ; x16
+        .FILL BAD_TRAP    ; x17
+        .FILL BAD_TRAP    ; x18
+        .FILL BAD_TRAP    ; x19
+        .FILL BAD_TRAP    ; x1A
+        .FILL BAD_TRAP    ; x1B
+        .FILL BAD_TRAP    ; x1C
+        .FILL BAD_TRAP    ; x1D
+        .FILL BAD_TRAP    ; x1E
+        .FILL BAD_TRAP    ; x1F
+        .FILL TRAP_GETC   ; x20
+        .FILL TRAP_OUT    ; x21
+        .FILL TRAP_PUTS   ; x22
+        .FILL TRAP_IN     ; x23
+        .FILL TRAP_PUTSP  ; x24
+        .FILL TRAP_HALT   ; x25
+        .FILL BAD_TRAP    ; x26
+        .FILL BAD_TRAP    ; x27
+        .FILL BAD_TRAP    ; x28
+        .FILL BAD_TRAP    ; x29
+        .FILL BAD_TRAP    ; x2A
+        .FILL BAD_TRAP    ; x2B
+        .FILL BAD_TRAP    ; x2C
+        .FILL BAD_TRAP    ; x2D
+        .FILL BAD_TRAP    ; x2E
+        .FILL BAD_TRAP    ; x2F
+        .FILL BAD_TRAP    ; x30
+        .FILL BAD_TRAP    ; x31
+        .FILL BAD_TRAP    ; x32
+        .FILL BAD_TRAP    ; x33
+        .FILL BAD_TRAP    ; x34
+        .FILL BAD_TRAP    ; x35
+        .FILL BAD_TRAP    ; x36
+        .FILL BAD_TRAP    ; x37
+        .FILL BAD_TRAP    ; x38
+        .FILL BAD_TRAP    ; x39
+        .FILL BAD_TRAP    ; x3A
+        .FILL BAD_TRAP    ; x3B
+        .FILL BAD_TRAP    ; x3C
+        .FILL BAD_TRAP    ; x3D
+        .FILL BAD_TRAP    ; x3E
+        .FILL BAD_TRAP    ; x3F
+        .FILL BAD_TRAP    ; x40
+        .FILL BAD_TRAP    ; x41
+        .FILL BAD_TRAP    ; x42
+        .FILL BAD_TRAP    ; x43
+        .FILL BAD_TRAP    ; x44
+        .FILL BAD_TRAP    ; x45
+        .FILL BAD_TRAP    ; x46
+        .FILL BAD_TRAP    ; x47
+        .FILL BAD_TRAP    ; x48
+        .FILL BAD_TRAP    ; x49
+        .FILL BAD_TRAP    ; x4A
+        .FILL BAD_TRAP    ; x4B
+        .FILL BAD_TRAP    ; x4C
+        .FILL BAD_TRAP    ; x4D
+        .FILL BAD_TRAP    ; x4E
+        .FILL BAD_TRAP    ; x4F
+        .FILL BAD_TRAP    ; x50
+        .FILL BAD_TRAP    ; x51
+        .FILL BAD_TRAP    ; x52
+        .FILL BAD_TRAP    ; x53
+        .FILL BAD_TRAP    ; x54
+        .FILL BAD_TRAP    ; x55
+        .FILL BAD_TRAP    ; x56
+        .FILL BAD_TRAP    ; x57
+        .FILL BAD_TRAP    ; x58
+        .FILL BAD_TRAP    ; x59
+        .FILL BAD_TRAP    ; x5A
+        .FILL BAD_TRAP    ; x5B
+        .FILL BAD_TRAP    ; x5C
+        .FILL BAD_TRAP    ; x5D
+        .FILL BAD_TRAP    ; x5E
+        .FILL BAD_TRAP    ; x5F
+        .FILL BAD_TRAP    ; x60
+        .FILL BAD_TRAP    ; x61
+        .FILL BAD_TRAP    ; x62
+        .FILL BAD_TRAP    ; x63
+        .FILL BAD_TRAP    ; x64
+        .FILL BAD_TRAP    ; x65
+        .FILL BAD_TRAP    ; x66
+        .FILL BAD_TRAP    ; x67
+        .FILL BAD_TRAP    ; x68
+        .FILL BAD_TRAP    ; x69
+        .FILL BAD_TRAP    ; x6A
+        .FILL BAD_TRAP    ; x6B
+        .FILL BAD_TRAP    ; x6C
+        .FILL BAD_TRAP    ; x6D
+        .FILL BAD_TRAP    ; x6E
+        .FILL BAD_TRAP    ; x6F
+        .FILL BAD_TRAP    ; x70
+        .FILL BAD_TRAP    ; x71
+        .FILL BAD_TRAP    ; x72
+        .FILL BAD_TRAP    ; x73
+        .FILL BAD_TRAP    ; x74
+        .FILL BAD_TRAP    ; x75
+        .FILL BAD_TRAP    ; x76
+        .FILL BAD_TRAP    ; x77
+        .FILL BAD_TRAP    ; x78
+        .FILL BAD_TRAP    ; x79
+        .FILL BAD_TRAP    ; x7A
+        .FILL BAD_TRAP    ; x7B
+        .FILL BAD_TRAP    ; x7C
+        .FILL BAD_TRAP    ; x7D
+        .FILL BAD_TRAP    ; x7E
+        .FILL BAD_TRAP    ; x7F
+        .FILL BAD_TRAP    ; x80
+        .FILL BAD_TRAP    ; x81
+        .FILL BAD_TRAP    ; x82
+        .FILL BAD_TRAP    ; x83
+        .FILL BAD_TRAP    ; x84
+        .FILL BAD_TRAP    ; x85
+        .FILL BAD_TRAP    ; x86
+        .FILL BAD_TRAP    ; x87
+        .FILL BAD_TRAP    ; x88
+        .FILL BAD_TRAP    ; x89
+        .FILL BAD_TRAP    ; x8A
+        .FILL BAD_TRAP    ; x8B
+        .FILL BAD_TRAP    ; x8C
+        .FILL BAD_TRAP    ; x8D
+        .FILL BAD_TRAP    ; x8E
+        .FILL BAD_TRAP    ; x8F
+        .FILL BAD_TRAP    ; x90
+        .FILL BAD_TRAP    ; x91
+        .FILL BAD_TRAP    ; x92
+        .FILL BAD_TRAP    ; x93
+        .FILL BAD_TRAP    ; x94
+        .FILL BAD_TRAP    ; x95
+        .FILL BAD_TRAP    ; x96
+        .FILL BAD_TRAP    ; x97
+        .FILL BAD_TRAP    ; x98
+        .FILL BAD_TRAP    ; x99
+        .FILL BAD_TRAP    ; x9A
+        .FILL BAD_TRAP    ; x9B
+        .FILL BAD_TRAP    ; x9C
+        .FILL BAD_TRAP    ; x9D
+        .FILL BAD_TRAP    ; x9E
+        .FILL BAD_TRAP    ; x9F
+        .FILL BAD_TRAP    ; xA0
+        .FILL BAD_TRAP    ; xA1
+        .FILL BAD_TRAP    ; xA2
+        .FILL BAD_TRAP    ; xA3
+        .FILL BAD_TRAP    ; xA4
+        .FILL BAD_TRAP    ; xA5
+        .FILL BAD_TRAP    ; xA6
+        .FILL BAD_TRAP    ; xA7
+        .FILL BAD_TRAP    ; xA8
+        .FILL BAD_TRAP    ; xA9
+        .FILL BAD_TRAP    ; xAA
+        .FILL BAD_TRAP    ; xAB
+        .FILL BAD_TRAP    ; xAC
+        .FILL BAD_TRAP    ; xAD
+        .FILL BAD_TRAP    ; xAE
+        .FILL BAD_TRAP    ; xAF
+        .FILL BAD_TRAP    ; xB0
+        .FILL BAD_TRAP    ; xB1
+        .FILL BAD_TRAP    ; xB2
+        .FILL BAD_TRAP    ; xB3
+        .FILL BAD_TRAP    ; xB4
+        .FILL BAD_TRAP    ; xB5
+        .FILL BAD_TRAP    ; xB6
+        .FILL BAD_TRAP    ; xB7
+        .FILL BAD_TRAP    ; xB8
+        .FILL BAD_TRAP    ; xB9
+        .FILL BAD_TRAP    ; xBA
+        .FILL BAD_TRAP    ; xBB
+        .FILL BAD_TRAP    ; xBC
+        .FILL BAD_TRAP    ; xBD
+        .FILL BAD_TRAP    ; xBE
+        .FILL BAD_TRAP    ; xBF
+        .FILL BAD_TRAP    ; xC0
+        .FILL BAD_TRAP    ; xC1
+        .FILL BAD_TRAP    ; xC2
+        .FILL BAD_TRAP    ; xC3
+        .FILL BAD_TRAP    ; xC4
+        .FILL BAD_TRAP    ; xC5
+        .FILL BAD_TRAP    ; xC6
+        .FILL BAD_TRAP    ; xC7
+        .FILL BAD_TRAP    ; xC8
+        .FILL BAD_TRAP    ; xC9
+        .FILL BAD_TRAP    ; xCA
+        .FILL BAD_TRAP    ; xCB
+        .FILL BAD_TRAP    ; xCC
+        .FILL BAD_TRAP    ; xCD
+        .FILL BAD_TRAP    ; xCE
+        .FILL BAD_TRAP    ; xCF
+        .FILL BAD_TRAP    ; xD0
+        .FILL BAD_TRAP    ; xD1
+        .FILL BAD_TRAP    ; xD2
+        .FILL BAD_TRAP    ; xD3
+        .FILL BAD_TRAP    ; xD4
+        .FILL BAD_TRAP    ; xD5
+        .FILL BAD_TRAP    ; xD6
+        .FILL BAD_TRAP    ; xD7
+        .FILL BAD_TRAP    ; xD8
+        .FILL BAD_TRAP    ; xD9
+        .FILL BAD_TRAP    ; xDA
+        .FILL BAD_TRAP    ; xDB
+        .FILL BAD_TRAP    ; xDC
+        .FILL BAD_TRAP    ; xDD
+        .FILL BAD_TRAP    ; xDE
+        .FILL BAD_TRAP    ; xDF
+        .FILL BAD_TRAP    ; xE0
+        .FILL BAD_TRAP    ; xE1
+        .FILL BAD_TRAP    ; xE2
+        .FILL BAD_TRAP    ; xE3
+        .FILL BAD_TRAP    ; xE4
+        .FILL BAD_TRAP    ; xE5
+        .FILL BAD_TRAP    ; xE6
+        .FILL BAD_TRAP    ; xE7
+        .FILL BAD_TRAP    ; xE8
+        .FILL BAD_TRAP    ; xE9
+        .FILL BAD_TRAP    ; xEA
+        .FILL BAD_TRAP    ; xEB
+        .FILL BAD_TRAP    ; xEC
+        .FILL BAD_TRAP    ; xED
+        .FILL BAD_TRAP    ; xEE
+        .FILL BAD_TRAP    ; xEF
+        .FILL BAD_TRAP    ; xF0
+        .FILL BAD_TRAP    ; xF1
+        .FILL BAD_TRAP    ; xF2
+        .FILL BAD_TRAP    ; xF3
+        .FILL BAD_TRAP    ; xF4
+        .FILL BAD_TRAP    ; xF5
+        .FILL BAD_TRAP    ; xF6
+        .FILL BAD_TRAP    ; xF7
+        .FILL BAD_TRAP    ; xF8
+        .FILL BAD_TRAP    ; xF9
+        .FILL BAD_TRAP    ; xFA
+        .FILL BAD_TRAP    ; xFB
+        .FILL BAD_TRAP    ; xFC
+        .FILL BAD_TRAP    ; xFD
+        .FILL BAD_TRAP    ; xFE
+        .FILL BAD_TRAP    ; xFF
+
+        .BLKW x100              ; interrupt vector table
+
+; GETC: read a character from the keyboard into R0, without echo
+TRAP_GETC
+        LDI R0, OS_KBSR
+        BRzp TRAP_GETC
+        LDI R0, OS_KBDR
+        RTI
+
+; OUT: write the character in R0 to the display
+TRAP_OUT
+        ST R1, OUT_R1
+OUT_WAIT
+        LDI R1, OS_DSR
+        BRzp OUT_WAIT
+        STI R0, OS_DDR
+        LD R1, OUT_R1
+        RTI
+OUT_R1  .BLKW 1
+
+; PUTS: write the string of one character per word starting at R0
+TRAP_PUTS
+        ST R0, PUTS_R0
+        ST R1, PUTS_R1
+        ST R2, PUTS_R2
+PUTS_NEXT
+        LDR R1, R0, #0
+        BRz PUTS_DONE
+PUTS_WAIT
+        LDI R2, OS_DSR
+        BRzp PUTS_WAIT
+        STI R1, OS_DDR
+        ADD R0, R0, #1
+        BRnzp PUTS_NEXT
+PUTS_DONE
+        LD R0, PUTS_R0
+        LD R1, PUTS_R1
+        LD R2, PUTS_R2
+        RTI
+PUTS_R0 .BLKW 1
+PUTS_R1 .BLKW 1
+PUTS_R2 .BLKW 1
+
+; IN: prompt for a character, read it into R0 and echo it
+TRAP_IN
+        ST R7, IN_R7
+        LEA R0, IN_PROMPT
+        PUTS
+        GETC
+        OUT
+        LD R7, IN_R7
+        RTI
+IN_R7   .BLKW 1
+IN_PROMPT
+        .STRINGZ "Enter a character: "
+
+; PUTSP: write the string of two characters per word starting at R0,
+; low byte first
+TRAP_PUTSP
+        ST R0, PUTSP_R0
+        ST R1, PUTSP_R1
+        ST R2, PUTSP_R2
+        ST R3, PUTSP_R3
+        ST R4, PUTSP_R4
+        ST R5, PUTSP_R5
+        ST R7, PUTSP_R7
+        ADD R1, R0, #0
+PUTSP_NEXT
+        LDR R2, R1, #0
+        BRz PUTSP_DONE
+        LD R0, LOW_BYTE
+        AND R0, R2, R0
+        OUT
+        AND R0, R0, #0          ; shift the high byte down one bit at a time
+        LD R3, HIGH_BIT
+        AND R4, R4, #0
+        ADD R4, R4, #1
+PUTSP_SHIFT
+        AND R5, R2, R3
+        BRz PUTSP_ZERO
+        ADD R0, R0, R4
+PUTSP_ZERO
+        ADD R4, R4, R4
+        ADD R3, R3, R3
+        BRnp PUTSP_SHIFT
+        ADD R0, R0, #0
+        BRz PUTSP_SKIP          ; an odd length string ends with a zero high byte
+        OUT
+PUTSP_SKIP
+        ADD R1, R1, #1
+        BRnzp PUTSP_NEXT
+PUTSP_DONE
+        LD R0, PUTSP_R0
+        LD R1, PUTSP_R1
+        LD R2, PUTSP_R2
+        LD R3, PUTSP_R3
+        LD R4, PUTSP_R4
+        LD R5, PUTSP_R5
+        LD R7, PUTSP_R7
+        RTI
+PUTSP_R0 .BLKW 1
+PUTSP_R1 .BLKW 1
+PUTSP_R2 .BLKW 1
+PUTSP_R3 .BLKW 1
+PUTSP_R4 .BLKW 1
+PUTSP_R5 .BLKW 1
+PUTSP_R7 .BLKW 1
+LOW_BYTE .FILL x00FF
+HIGH_BIT .FILL x0100
+
+; HALT: stop the machine by clearing the clock enable bit of the MCR,
+; returning if the clock is enabled again. A message is printed first
+; if HALT_BANNER is set, see ALU.ShowHaltBanner.
+TRAP_HALT
+        ST R0, HALT_R0
+        ST R1, HALT_R1
+        ST R7, HALT_R7
+        LD R0, HALT_BANNER
+        BRz HALT_STOP
+        LEA R0, HALT_MSG
+        PUTS
+HALT_STOP
+        LDI R0, OS_MCR
+        LD R1, CLOCK_OFF
+        AND R0, R0, R1
+        LD R1, HALT_R1
+        LD R7, HALT_R7
+        STI R0, OS_MCR          ; the machine stops here, with R0 holding the MCR
+        LD R0, HALT_R0
+        RTI
+HALT_R0 .BLKW 1
+HALT_R1 .BLKW 1
+HALT_R7 .BLKW 1
+HALT_BANNER
+        .FILL 0
+HALT_MSG
+        .STRINGZ "\n--- halting the LC-3 ---\n"
+CLOCK_OFF
+        .FILL x7FFF
+
+; any other vector: report it and halt
+BAD_TRAP
+        LEA R0, BAD_TRAP_MSG
+        PUTS
+        BRnzp TRAP_HALT
+BAD_TRAP_MSG
+        .STRINGZ "\n--- undefined trap executed ---\n"
+
+OS_KBSR .FILL xFE00
+OS_KBDR .FILL xFE02
+OS_DSR  .FILL xFE04
+OS_DDR  .FILL xFE06
+OS_MCR  .FILL xFFFE
+
+        .END
@@ -2,22 +2,173 @@ package main
 
 import (
 	"encoding/binary"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"strings"
 )
 
-// Load loads a binary file located at the given path in the given buffer
-func Load(memory *[65536]uint16, path string) error {
+// Segment is a range of consecutive words loaded into memory
+type Segment struct {
+	Origin uint16
+	Length int
+}
+
+// End returns the address following the segment, which is 0x10000 for a segment ending at xFFFF
+func (s Segment) End() int {
+	return int(s.Origin) + s.Length
+}
+
+// String returns the address range of the segment
+func (s Segment) String() string {
+	return fmt.Sprintf("x%04X-x%04X", s.Origin, s.End()-1)
+}
+
+// chunk is a segment of an object file with its contents
+type chunk struct {
+	Segment
+	words []uint16
+}
+
+// Load loads an object file located at the given path in the given buffer
+// and returns the segments it occupies. The format is detected, see formatOf.
+func Load(memory *[65536]uint16, path string) ([]Segment, error) {
+	chunks, err := readObjectFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return loadChunks(memory, chunks), nil
+}
+
+// LoadReader loads an object file read from r in any of the supported formats.
+// Nothing is loaded if the file is invalid.
+func LoadReader(memory *[65536]uint16, r io.Reader) ([]Segment, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return LoadBytes(memory, b)
+}
+
+// LoadBytes loads the contents of an object file in any of the supported formats.
+// Nothing is loaded if the file is invalid.
+func LoadBytes(memory *[65536]uint16, b []byte) ([]Segment, error) {
+	chunks, err := parseObject(DetectFormat(b), b)
+	if err != nil {
+		return nil, err
+	}
+	return loadChunks(memory, chunks), nil
+}
+
+// LoadFiles loads several object files in order and returns the segments of each file.
+// Nothing is loaded if any file is invalid or the segments of two files overlap.
+func LoadFiles(memory *[65536]uint16, paths []string) ([][]Segment, error) {
+	files := make([][]chunk, len(paths))
+	var overlaps []string
+	for i, path := range paths {
+		chunks, err := readObjectFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for j, other := range files[:i] {
+			for _, c := range chunks {
+				for _, o := range other {
+					if int(c.Origin) < o.End() && int(o.Origin) < c.End() {
+						overlaps = append(overlaps, fmt.Sprintf("%s (%s) overlaps %s (%s)", path, c.Segment, paths[j], o.Segment))
+					}
+				}
+			}
+		}
+		files[i] = chunks
+	}
+	if len(overlaps) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(overlaps, "\n"))
+	}
+
+	segments := make([][]Segment, len(paths))
+	for i, chunks := range files {
+		segments[i] = loadChunks(memory, chunks)
+	}
+	return segments, nil
+}
+
+// readObjectFile reads and validates the object file at path
+func readObjectFile(path string) ([]chunk, error) {
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	chunks, err := parseObject(formatOf(path, b), b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
 	}
+	return chunks, nil
+}
 
-	origin := binary.BigEndian.Uint16(b[:2])
+// parseObject validates an object file in the given format and returns its contents
+func parseObject(format Format, b []byte) ([]chunk, error) {
+	switch format {
+	case FormatLC3Tools:
+		return parseLC3Tools(b)
+	case FormatHex:
+		return parseText(b, 16)
+	case FormatBin:
+		return parseText(b, 2)
+	}
+	return parseRaw(b)
+}
 
-	for i := 2; i < len(b); i += 2 {
-		memory[origin] = binary.BigEndian.Uint16(b[i : i+2])
-		origin++
+// parseRaw validates a raw object file: a big-endian origin followed by the words to load there
+func parseRaw(b []byte) ([]chunk, error) {
+	switch {
+	case len(b) == 0:
+		return nil, fmt.Errorf("empty object file")
+	case len(b)%2 != 0:
+		return nil, fmt.Errorf("truncated object file: odd length of %d bytes", len(b))
 	}
 
-	return nil
+	words := make([]uint16, len(b)/2)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return originChunk(words)
+}
+
+// originChunk returns the segment described by an origin followed by the words to load there
+func originChunk(words []uint16) ([]chunk, error) {
+	switch len(words) {
+	case 0:
+		return nil, fmt.Errorf("empty object file")
+	case 1:
+		return nil, fmt.Errorf("truncated object file: no words after the origin")
+	}
+
+	c := chunk{Segment: Segment{Origin: words[0], Length: len(words) - 1}, words: words[1:]}
+	if c.End() > 0x10000 {
+		return nil, fmt.Errorf("%d words at x%04X run past xFFFF", c.Length, c.Origin)
+	}
+	return []chunk{c}, nil
+}
+
+func loadChunks(memory *[65536]uint16, chunks []chunk) []Segment {
+	segments := make([]Segment, len(chunks))
+	for i, c := range chunks {
+		copy(memory[c.Origin:], c.words)
+		segments[i] = c.Segment
+	}
+	return segments
+}
+
+// segmentsRange returns the first and last address occupied by segments
+func segmentsRange(segments []Segment) (uint16, uint16) {
+	first, end := 0x10000, 0
+	for _, s := range segments {
+		if int(s.Origin) < first {
+			first = int(s.Origin)
+		}
+		if s.End() > end {
+			end = s.End()
+		}
+	}
+	return uint16(first), uint16(end - 1)
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeObj writes an object file with the given origin and words to dir
+func writeObj(t *testing.T, dir, name string, origin uint16, words ...uint16) string {
+	b := make([]byte, 2*len(words)+2)
+	binary.BigEndian.PutUint16(b, origin)
+	for i, w := range words {
+		binary.BigEndian.PutUint16(b[2*i+2:], w)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	prog := writeObj(t, dir, "prog.obj", 0x3000, 0x1111, 0x2222)
+	lib := writeObj(t, dir, "lib.obj", 0x4000, 0x3333)
+	overlapping := writeObj(t, dir, "overlap.obj", 0x3001, 0x4444, 0x5555)
+
+	var memory [65536]uint16
+	segments, err := LoadFiles(&memory, []string{prog, lib})
+	assert.NoError(err)
+	assert.Equal([][]Segment{{{0x3000, 2}}, {{0x4000, 1}}}, segments)
+	assert.Equal([]uint16{0x1111, 0x2222}, memory[0x3000:0x3002])
+	assert.Equal(uint16(0x3333), memory[0x4000])
+
+	memory = [65536]uint16{}
+	_, err = LoadFiles(&memory, []string{prog, lib, overlapping})
+	if assert.Error(err) {
+		assert.Equal(overlapping+" (x3001-x3002) overlaps "+prog+" (x3000-x3001)", err.Error())
+	}
+	assert.Equal(uint16(0), memory[0x3000], "Nothing should be loaded when files overlap")
+}
+
+func TestLoadBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		description string
+		b           []byte
+
+		expectedSegments []Segment
+		expectedErr      string
+	}{
+		{
+			description:      "Valid file",
+			b:                []byte{0x30, 0x00, 0x12, 0x34, 0x56, 0x78},
+			expectedSegments: []Segment{{0x3000, 2}},
+		},
+		{
+			description:      "File ending at xFFFF",
+			b:                []byte{0xFF, 0xFF, 0x12, 0x34},
+			expectedSegments: []Segment{{0xFFFF, 1}},
+		},
+		{
+			description: "Empty file",
+			b:           nil,
+			expectedErr: "empty object file",
+		},
+		{
+			description: "Odd length",
+			b:           []byte{0x30, 0x00, 0x12},
+			expectedErr: "truncated object file: odd length of 3 bytes",
+		},
+		{
+			description: "Origin only",
+			b:           []byte{0x30, 0x00},
+			expectedErr: "truncated object file: no words after the origin",
+		},
+		{
+			description: "Overflow",
+			b:           []byte{0xFF, 0xFF, 0x12, 0x34, 0x56, 0x78},
+			expectedErr: "2 words at xFFFF run past xFFFF",
+		},
+	}
+
+	for _, testData := range tests {
+		var memory [65536]uint16
+		segments, err := LoadBytes(&memory, testData.b)
+		if testData.expectedErr != "" {
+			assert.EqualError(err, testData.expectedErr, testData.description)
+			assert.Equal([65536]uint16{}, memory, "Nothing should be loaded for %s", testData.description)
+			continue
+		}
+		assert.NoError(err, testData.description)
+		assert.Equal(testData.expectedSegments, segments, testData.description)
+		assert.Equal(uint16(0x1234), memory[segments[0].Origin], testData.description)
+	}
+}
+
+func TestLoadReader(t *testing.T) {
+	assert := assert.New(t)
+
+	var memory [65536]uint16
+	segments, err := LoadReader(&memory, bytes.NewReader([]byte{0x40, 0x00, 0xF0, 0x25}))
+	assert.NoError(err)
+	assert.Equal([]Segment{{0x4000, 1}}, segments)
+	assert.Equal(uint16(0xF025), memory[0x4000])
+
+	_, err = Load(&memory, filepath.Join(t.TempDir(), "missing.obj"))
+	assert.Error(err)
+
+	path := filepath.Join(t.TempDir(), "odd.obj")
+	assert.NoError(ioutil.WriteFile(path, []byte{1, 2, 3}, 0644))
+	_, err = Load(&memory, path)
+	assert.EqualError(err, path+": truncated object file: odd length of 3 bytes")
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 )
 
@@ -14,10 +15,19 @@ const (
 
 // Memory addresses
 const (
-	PCStart = 0x3000 // program counter
+	PCStart  = 0x3000 // program counter
+	SSPStart = 0x3000 // supervisor stack pointer
+
+	IntVectorTable = 0x0100 // interrupt vector table
+	UserSpace      = 0x3000 // first address accessible in user mode
+	DeviceSpace    = 0xFE00 // first device register address, not accessible in user mode
 
 	KBSR = 0xFE00 // keyboard status register
 	KBDR = 0xFE02 // keyboard data register
+	DSR  = 0xFE04 // display status register
+	DDR  = 0xFE06 // display data register
+	PSR  = 0xFFFC // processor status register
+	MCR  = 0xFFFE // machine control register
 )
 
 // Instructions
@@ -30,7 +40,7 @@ const (
 	OpAND                // bitwise and
 	OpLDR                // load register
 	OpSTR                // store register
-	OpRTI                // unused
+	OpRTI                // return from interrupt
 	OpNOT                // bitwise not
 	OpLDI                // load indirect
 	OpSTI                // store indirect
@@ -55,18 +65,90 @@ type ALU struct {
 	CondReg uint16    // conditional register
 	PCReg   uint16    // program counter register
 
-	Memory [65536]uint16 // memory
+	User     bool   // user mode, supervisor mode otherwise
+	Priority uint16 // priority level, 0 to 7
+	SavedSSP uint16 // supervisor stack pointer while in user mode
+	SavedUSP uint16 // user stack pointer while in supervisor mode
+
+	Memory  [65536]uint16 // memory
+	devices []busMapping  // memory-mapped devices, see Map
+	polled  []*Device     // devices with a Tick or Interrupt callback
+
+	keyboard *keyboard
+	events   chan func() // device events run by the CPU, see Post
+
+	In    io.Reader // console input, standard input if nil
+	Out   io.Writer // console output, standard output if nil
+	Trace io.Writer // receives a line for every instruction executed if not nil
+
+	Symbols *SymbolTable // labels used in traces and error reports, may be nil
 
-	KBSRChan chan struct{} // keyboard ready channel
+	traps   map[uint8]TrapHandler // traps implemented in Go, see RegisterTrap
+	keyWait func() bool           // asked for a key before a trap blocks on the keyboard, see Debugger
+	entered int                   // interrupt and exception handlers entered, see Debugger
 
-	Running bool
+	EOF      EOFPolicy // what a program waiting for a key gets after the end of the input
+	Sentinel uint16    // character typed after the end of the input with EOFSentinel
+
+	TypeAhead         int            // keys buffered while KBDR holds an unread one
+	TypeAheadOverflow OverflowPolicy // which typed key is lost when the buffer is full
+
+	Running    bool
+	Err        error // why the machine stopped if it did not halt normally
+	ExitStatus int   // status the program exited with, see TrapEXIT
+
+	instrAddr uint16 // address of the instruction being executed
+}
+
+// NewALU returns a running machine starting at PCStart, with the default operating system loaded
+func NewALU() *ALU {
+	a := &ALU{
+		PCReg:     PCStart,
+		SavedSSP:  SSPStart,
+		Running:   true,
+		keyboard:  &keyboard{room: make(chan struct{}, 1)},
+		events:    make(chan func(), eventQueueSize),
+		TypeAhead: DefaultTypeAhead,
+	}
+	kbd := a.keyboardDevice()
+	a.Map(KBSR, KBSR, kbd)
+	a.Map(KBDR, KBDR, kbd)
+	disp := a.displayDevice()
+	a.Map(DSR, DSR, disp)
+	a.Map(DDR, DDR, disp)
+	a.Map(PSR, PSR, a.psrDevice())
+	a.Map(MCR, MCR, a.mcrDevice())
+
+	// programs start in supervisor mode, so R6 is the supervisor stack pointer
+	a.Reg[6] = SSPStart
+
+	image := defaultOS()
+	copy(a.Memory[image.Origin:], image.Words)
+	return a
 }
 
 func (a *ALU) EmulateInstruction() {
-	instr := a.Memory[a.PCReg]
+	a.drainEvents()
+	a.tick()
+	if vector, priority, ok := a.pendingInterrupt(); ok {
+		// the handler starts with the next call, so that it can stop at its first instruction
+		a.instrAddr = a.PCReg
+		a.interrupt(vector, priority)
+		return
+	}
+
+	a.instrAddr = a.PCReg
+	if !a.accessible(a.PCReg) {
+		return
+	}
+	instr := a.Read(a.PCReg)
 	a.PCReg++
 
-	switch op := subBits(instr, 15, 12); op {
+	if a.Trace != nil {
+		fmt.Fprintf(a.Trace, "%s  x%04X  %s\n", a.Symbols.Describe(a.instrAddr), instr, DisassembleSym(instr, a.instrAddr, a.Symbols))
+	}
+
+	switch Decode(instr).Op {
 	case OpBR:
 		a.handleBR(instr)
 	case OpADD:
@@ -84,7 +166,7 @@ func (a *ALU) EmulateInstruction() {
 	case OpSTR:
 		a.handleSTR(instr)
 	case OpRTI:
-		panic("OpRTI is unused")
+		a.handleRTI(instr)
 	case OpNOT:
 		a.handleNOT(instr)
 	case OpLDI:
@@ -94,7 +176,7 @@ func (a *ALU) EmulateInstruction() {
 	case OpJMP:
 		a.handleJMP(instr)
 	case OpRES:
-		panic("OpRES is unused")
+		a.raise(ExcIllegalOpcode)
 	case OpLEA:
 		a.handleLEA(instr)
 	case OpTRAP:
@@ -102,43 +184,43 @@ func (a *ALU) EmulateInstruction() {
 	}
 }
 
+// input returns the reader console input comes from
+func (a *ALU) input() io.Reader {
+	if a.In == nil {
+		return os.Stdin
+	}
+	return a.In
+}
+
+// output returns the writer console output goes to
+func (a *ALU) output() io.Writer {
+	if a.Out == nil {
+		return os.Stdout
+	}
+	return a.Out
+}
+
+// ExitCode returns the exit status of a stopped machine, 1 if it stopped on an error
+func (a *ALU) ExitCode() int {
+	if a.Err != nil {
+		return 1
+	}
+	return a.ExitStatus
+}
+
+// handleTRAP saves the return address in R7 and runs the Go handler registered for the trap.
+// Without one it pushes PSR and PC onto the supervisor stack like an interrupt and jumps to the
+// service routine found in the trap vector table in supervisor mode; the routine returns with RTI.
 func (a *ALU) handleTRAP(instr uint16) {
-	switch trapVector := subBits(instr, 7, 0); trapVector {
-	case TrapGETC:
-		// block until new character received
-		<- a.KBSRChan
-		a.Reg[0] = a.Memory[KBDR]
-		a.Memory[KBSR] &= 0x7FFF
-	case TrapOUT:
-		fmt.Printf("%c", rune(a.Reg[0]))
-	case TrapPUTS:
-		address := a.Reg[0]
-		var chr uint16
-		var i uint16
-		for ok := true; ok; ok = (chr != 0x0) {
-			chr = a.Memory[address+i] & 0xFFFF
-			fmt.Printf("%c", rune(chr))
-			i++
-		}
-	case TrapIN:
-		fmt.Print("Enter a character: ")
-		// block until new character received
-		<- a.KBSRChan
-		a.Reg[0] = a.Memory[KBDR]
-		a.Memory[KBSR] &= 0x7FFF
-		fmt.Printf("%c", rune(a.Reg[0]))
-	case TrapPUTSP:
-		for i := a.Reg[0]; a.Memory[i] != 0; i++ {
-			r1 := rune(a.Memory[i] & 0xFF)
-			fmt.Printf("%c", r1)
-			r2 := rune(a.Memory[i] >> 8)
-			if r2 != 0 {
-				fmt.Printf("%c", r2)
-			}
-		}
-	case TrapHALT:
-		a.Running = false
+	vect := Decode(instr).TrapVect
+	a.Reg[7] = a.PCReg
+
+	if h, ok := a.traps[uint8(vect)]; ok {
+		h(a)
+		return
 	}
+	a.enterSupervisor()
+	a.PCReg = a.Read(vect)
 }
 
 func (a *ALU) SetCC(r uint16) {
@@ -152,123 +234,161 @@ func (a *ALU) SetCC(r uint16) {
 }
 
 func (a *ALU) handleBR(instr uint16) {
-	pcOffset := subBits(instr, 8, 0)
-	flag := subBits(instr, 11, 9)
+	d := Decode(instr)
 
-	if flag & a.CondReg != 0 {
-		a.PCReg += signExtend(pcOffset, 9)
+	if d.NZP & a.CondReg != 0 {
+		a.PCReg += d.PCOffset9
 	}
 }
 
 func (a *ALU) handleADD(instr uint16) {
-	dr := subBits(instr, 11, 9)
-	sr1 := subBits(instr, 8, 6)
+	d := Decode(instr)
 
 	var s uint16
-	if subBits(instr, 5, 5) == 0 {
-		s = a.Reg[subBits(instr, 2, 0)]
+	if d.Imm {
+		s = d.Imm5
 	} else {
-		imm := subBits(instr, 4, 0)
-		s = signExtend(imm, 5)
+		s = a.Reg[d.SR2]
 	}
 
-	a.Reg[dr] = a.Reg[sr1] + s
-	a.SetCC(dr)
+	a.Reg[d.DR] = a.Reg[d.SR1] + s
+	a.SetCC(d.DR)
 }
 
 func (a *ALU) handleLD(instr uint16) {
-	dr := subBits(instr, 11, 9)
-	pcOffset := subBits(instr, 8, 0)
+	d := Decode(instr)
+
+	addr := a.PCReg + d.PCOffset9
+	if !a.accessible(addr) {
+		return
+	}
 
-	a.Reg[dr] = a.Memory[a.PCReg+signExtend(pcOffset, 9)]
-	a.SetCC(dr)
+	a.Reg[d.DR] = a.Read(addr)
+	a.SetCC(d.DR)
 }
 
 func (a *ALU) handleAND(instr uint16) {
-	dr := subBits(instr, 11, 9)
-	sr1 := subBits(instr, 8, 6)
+	d := Decode(instr)
 
 	var s uint16
-	if subBits(instr, 5, 5) == 0 {
-		s = a.Reg[subBits(instr, 2, 0)]
+	if d.Imm {
+		s = d.Imm5
 	} else {
-		imm := subBits(instr, 4, 0)
-		s = signExtend(imm, 5)
+		s = a.Reg[d.SR2]
 	}
 
-	a.Reg[dr] = a.Reg[sr1] & s
-	a.SetCC(dr)
+	a.Reg[d.DR] = a.Reg[d.SR1] & s
+	a.SetCC(d.DR)
 }
 
 func (a *ALU) handleJSR(instr uint16) {
+	d := Decode(instr)
 	a.Reg[7] = a.PCReg
 
-	if subBits(instr, 11, 11) == 0 {
-		baseR := subBits(instr, 8, 6)
-		a.PCReg = a.Reg[baseR]
+	if !d.PCRel {
+		a.PCReg = a.Reg[d.BaseR]
 	} else {
-		a.PCReg += signExtend(subBits(instr, 10, 0), 11)
+		a.PCReg += d.PCOffset11
 	}
 }
 
 func (a *ALU) handleJMP(instr uint16) {
-	baseR := subBits(instr, 8, 6)
-	a.PCReg = a.Reg[baseR]
+	d := Decode(instr)
+	a.PCReg = a.Reg[d.BaseR]
 }
 
 func (a *ALU) handleLDI(instr uint16) {
-	dr := subBits(instr, 11, 9)
-	pcOffset := subBits(instr, 8, 0)
+	d := Decode(instr)
 
-	a.Reg[dr] = a.Memory[a.Memory[a.PCReg+signExtend(pcOffset, 9)]]
-	a.SetCC(dr)
+	ptr := a.PCReg + d.PCOffset9
+	if !a.accessible(ptr) {
+		return
+	}
+	addr := a.Read(ptr)
+	if !a.accessible(addr) {
+		return
+	}
+
+	a.Reg[d.DR] = a.Read(addr)
+	a.SetCC(d.DR)
 }
 
 func (a *ALU) handleLDR(instr uint16) {
-	dr := subBits(instr, 11, 9)
-	baseR := subBits(instr, 8, 6)
-	offset := subBits(instr, 5, 0)
+	d := Decode(instr)
 
-	a.Reg[dr] = a.Memory[a.Reg[baseR]+signExtend(offset, 6)]
-	a.SetCC(dr)
+	addr := a.Reg[d.BaseR] + d.Offset6
+	if !a.accessible(addr) {
+		return
+	}
+
+	a.Reg[d.DR] = a.Read(addr)
+	a.SetCC(d.DR)
 }
 
 func (a *ALU) handleLEA(instr uint16) {
-	dr := subBits(instr, 11, 9)
-	pcOffset := subBits(instr, 8, 0)
+	d := Decode(instr)
 
-	a.Reg[dr] = a.PCReg + signExtend(pcOffset, 9)
-	a.SetCC(dr)
+	a.Reg[d.DR] = a.PCReg + d.PCOffset9
+	a.SetCC(d.DR)
 }
 
 func (a *ALU) handleNOT(instr uint16) {
-	dr := subBits(instr, 11, 9)
-	sr := subBits(instr, 8, 6)
+	d := Decode(instr)
 
-	a.Reg[dr] = ^a.Reg[sr]
-	a.SetCC(dr)
+	a.Reg[d.DR] = ^a.Reg[d.SR1]
+	a.SetCC(d.DR)
 }
 
 func (a *ALU) handleST(instr uint16) {
-	sr := subBits(instr, 11, 9)
-	pcOffset := subBits(instr, 8, 0)
+	d := Decode(instr)
+
+	addr := a.PCReg + d.PCOffset9
+	if !a.accessible(addr) {
+		return
+	}
 
-	a.Memory[a.PCReg+signExtend(pcOffset, 9)] = a.Reg[sr]
+	a.Write(addr, a.Reg[d.DR])
 }
 
 func (a *ALU) handleSTI(instr uint16) {
-	sr := subBits(instr, 11, 9)
-	pcOffset := subBits(instr, 8, 0)
+	d := Decode(instr)
 
-	a.Memory[a.Memory[a.PCReg+signExtend(pcOffset, 9)]] = a.Reg[sr]
+	ptr := a.PCReg + d.PCOffset9
+	if !a.accessible(ptr) {
+		return
+	}
+	addr := a.Read(ptr)
+	if !a.accessible(addr) {
+		return
+	}
+
+	a.Write(addr, a.Reg[d.DR])
 }
 
 func (a *ALU) handleSTR(instr uint16) {
-	sr := subBits(instr, 11, 9)
-	baseR := subBits(instr, 8, 6)
-	offset := subBits(instr, 5, 0)
+	d := Decode(instr)
+
+	addr := a.Reg[d.BaseR] + d.Offset6
+	if !a.accessible(addr) {
+		return
+	}
+
+	a.Write(addr, a.Reg[d.DR])
+}
+
+func (a *ALU) handleRTI(instr uint16) {
+	if a.User {
+		a.raise(ExcPrivilege)
+		return
+	}
+
+	a.PCReg = a.pop()
+	a.SetPSR(a.pop())
 
-	a.Memory[a.Reg[baseR]+signExtend(offset, 6)] = a.Reg[sr]
+	if a.User {
+		a.SavedSSP = a.Reg[6]
+		a.Reg[6] = a.SavedUSP
+	}
 }
 
 func main() {
@@ -278,21 +398,28 @@ func main() {
 		return
 	}
 
-	disableInputBuffering()
-
-	a := ALU{
-		PCReg:   PCStart,
-		Running: true,
-		KBSRChan: make(chan struct{}, 1),
+	commands := map[string]func([]string) error{
+		"run":     runCommand,
+		"batch":   batchCommand,
+		"asm":     asmCommand,
+		"convert": convertCommand,
+		"disasm":  disasmCommand,
+		"debug":   debugCommand,
+		"gdb":     gdbCommand,
+		"dap":     dapCommand,
 	}
-
-	if err := Load(&a.Memory, args[0]); err != nil {
-		panic(err)
+	cmd, ok := commands[args[0]]
+	if ok {
+		args = args[1:]
+	} else {
+		cmd = runCommand
 	}
 
-	go processInput(&a)
-
-	for a.Running {
-		a.EmulateInstruction()
+	if err := cmd(args); err != nil {
+		if status, ok := err.(exitStatus); ok {
+			os.Exit(int(status))
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
@@ -475,3 +475,191 @@ func TestHandleSTR(t *testing.T) {
 	assert.Equal(initialReg, a.Reg, "Should be equal for 'HandleSTR'")
 	assert.Equal(a.Reg[5], a.Memory[0x0006], "Should be equal for 'HandleSTR'")
 }
+
+func TestHandleRTI(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		description string
+		user        bool
+		stack       [2]uint16 // PC, PSR
+
+		expectedPCReg    uint16
+		expectedUser     bool
+		expectedPriority uint16
+		expectedCondReg  uint16
+		expectedR6       uint16
+		expectedSavedSSP uint16
+	}{
+		{
+			description: "Returns to supervisor mode",
+			stack:       [2]uint16{0x3456, 0x0302},
+
+			expectedPCReg:    0x3456,
+			expectedPriority: 3,
+			expectedCondReg:  CondZRO,
+			expectedR6:       0x2FFE,
+			expectedSavedSSP: 0x1111,
+		},
+		{
+			description: "Returns to user mode and switches stacks",
+			stack:       [2]uint16{0x4000, 0x8001},
+
+			expectedPCReg:    0x4000,
+			expectedUser:     true,
+			expectedCondReg:  CondPOS,
+			expectedR6:       0xF000,
+			expectedSavedSSP: 0x2FFE,
+		},
+		{
+			description: "Raises a privilege mode violation in user mode",
+			user:        true,
+
+			expectedPCReg:    0x1000,
+			expectedPriority: 0,
+			expectedR6:       0x110F,
+			expectedSavedSSP: 0x1111,
+		},
+	}
+
+	for _, testData := range tests {
+		a := ALU{
+			PCReg:    PCStart,
+			User:     testData.user,
+			SavedSSP: 0x1111,
+			SavedUSP: 0xF000,
+		}
+		a.Reg[6] = 0x2FFC
+		a.Memory[0x2FFC] = testData.stack[0]
+		a.Memory[0x2FFD] = testData.stack[1]
+		a.Memory[IntVectorTable+ExcPrivilege] = 0x1000
+
+		a.handleRTI(0x8000)
+
+		assert.Equal(testData.expectedPCReg, a.PCReg, "Should be equal for %s", testData.description)
+		assert.Equal(testData.expectedUser, a.User, "Should be equal for %s", testData.description)
+		assert.Equal(testData.expectedPriority, a.Priority, "Should be equal for %s", testData.description)
+		assert.Equal(testData.expectedCondReg, a.CondReg, "Should be equal for %s", testData.description)
+		assert.Equal(testData.expectedR6, a.Reg[6], "Should be equal for %s", testData.description)
+		assert.Equal(testData.expectedSavedSSP, a.SavedSSP, "Should be equal for %s", testData.description)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	assert := assert.New(t)
+
+	a := ALU{
+		PCReg:    0x3005,
+		CondReg:  CondNEG,
+		User:     true,
+		Priority: 1,
+		SavedSSP: 0x3000,
+	}
+	a.Reg[6] = 0xFE00
+	a.Memory[IntVectorTable+0x80] = 0x1234
+
+	a.interrupt(0x80, 4)
+
+	assert.Equal(uint16(0x1234), a.PCReg)
+	assert.False(a.User)
+	assert.Equal(uint16(4), a.Priority)
+	assert.Equal(uint16(0xFE00), a.SavedUSP)
+	assert.Equal(uint16(0x2FFE), a.Reg[6])
+	assert.Equal(uint16(0x3005), a.Memory[0x2FFE])
+	assert.Equal(uint16(0x8104), a.Memory[0x2FFF])
+
+	a.handleRTI(0x8000)
+
+	assert.Equal(uint16(0x3005), a.PCReg)
+	assert.True(a.User)
+	assert.Equal(uint16(1), a.Priority)
+	assert.Equal(uint16(CondNEG), a.CondReg)
+	assert.Equal(uint16(0xFE00), a.Reg[6])
+	assert.Equal(uint16(0x3000), a.SavedSSP)
+}
+
+func TestExceptions(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		description string
+		user        bool
+		instr       uint16
+
+		expectedVector uint16
+	}{
+		{
+			description: "Illegal opcode",
+			instr:       0xD000,
+
+			expectedVector: ExcIllegalOpcode,
+		},
+		{
+			description: "RTI in user mode",
+			user:        true,
+			instr:       0x8000,
+
+			expectedVector: ExcPrivilege,
+		},
+		{
+			description: "Load from system space in user mode",
+			user:        true,
+			instr:       0x6040, // LDR R0, R1, #0
+
+			expectedVector: ExcAccessControl,
+		},
+		{
+			description: "Store to device registers in user mode",
+			user:        true,
+			instr:       0x7080, // STR R0, R2, #0
+
+			expectedVector: ExcAccessControl,
+		},
+	}
+
+	for _, testData := range tests {
+		a := NewALU()
+		a.User = testData.user
+		a.Reg[1] = 0x0200
+		a.Reg[2] = DeviceSpace
+		a.Reg[6] = 0x4000
+		a.Memory[PCStart] = testData.instr
+		a.Memory[IntVectorTable+testData.expectedVector] = 0x1000
+
+		a.EmulateInstruction()
+
+		assert.True(a.Running, "Should be running for %s", testData.description)
+		assert.Equal(uint16(0x1000), a.PCReg, "Should enter the handler for %s", testData.description)
+		assert.False(a.User, "Should be in supervisor mode for %s", testData.description)
+		assert.Equal(uint16(PCStart+1), a.Memory[a.Reg[6]], "Should push PC for %s", testData.description)
+	}
+}
+
+func TestUnhandledException(t *testing.T) {
+	assert := assert.New(t)
+
+	a := NewALU()
+	a.Memory[PCStart] = 0xD123
+
+	a.EmulateInstruction()
+
+	assert.False(a.Running)
+	if assert.Error(a.Err) {
+		assert.Equal("illegal opcode at x3000 (.FILL xD123): no handler installed at x0101", a.Err.Error())
+	}
+}
+
+func TestAccessControlOnFetch(t *testing.T) {
+	assert := assert.New(t)
+
+	a := NewALU()
+	a.User = true
+	a.PCReg = 0x2FFF
+
+	a.EmulateInstruction()
+
+	assert.False(a.Running)
+	if assert.Error(a.Err) {
+		assert.Equal("access control violation at x2FFF (.FILL x0000): no handler installed at x0102", a.Err.Error())
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	_ "embed"
+	"sync"
+)
+
+// lc3osSource is the default operating system: the trap vector table and the standard service routines
+//
+//go:embed lc3os.asm
+var lc3osSource []byte
+
+var (
+	lc3osOnce  sync.Once
+	lc3osImage *Program
+)
+
+// defaultOS returns the assembled default operating system
+func defaultOS() *Program {
+	lc3osOnce.Do(func() {
+		prog, err := Assemble("lc3os.asm", lc3osSource)
+		if err != nil {
+			panic(err)
+		}
+		lc3osImage = prog
+	})
+	return lc3osImage
+}
+
+// ShowHaltBanner sets whether the HALT routine of the default operating system prints
+// a message before stopping the machine. It is off on a new machine, so that program
+// output is left alone.
+func (a *ALU) ShowHaltBanner(show bool) {
+	var v uint16
+	if show {
+		v = 1
+	}
+	a.Memory[defaultOS().Symbols["HALT_BANNER"]] = v
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Semihosting traps. Strings are one character per word and terminated by zero,
+// file contents are transferred one byte per word. Failures return -1 in R0.
+const (
+	TrapOPEN  = 0x40 // open the file named at R0 with mode R1, returns a descriptor in R0
+	TrapREAD  = 0x41 // read up to R2 bytes from descriptor R0 to R1, returns the count, 0 at the end of the file
+	TrapWRITE = 0x42 // write R2 bytes at R1 to descriptor R0, returns the count
+	TrapCLOSE = 0x43 // close descriptor R0, returns 0
+	TrapSEEK  = 0x44 // move descriptor R0 by R1 relative to R2, returns 0 and the new position in R1
+	TrapEXIT  = 0x45 // halt with exit status R0, 0 to MaxExitStatus
+)
+
+// MaxExitStatus is the highest status TrapEXIT accepts. Statuses above it are left to shells,
+// which report signals from 128, and to the batch command. A higher R0 stops the machine with an error.
+const MaxExitStatus = 127
+
+// Modes of TrapOPEN
+const (
+	SemiRead      = 0 // read an existing file
+	SemiWrite     = 1 // write a new or truncated file
+	SemiAppend    = 2 // append to a new or existing file
+	SemiReadWrite = 3 // read and write an existing file
+)
+
+// Whence values of TrapSEEK
+const (
+	SemiSeekStart   = 0
+	SemiSeekCurrent = 1
+	SemiSeekEnd     = 2
+)
+
+// Semihost gives programs access to the files below a sandbox directory
+type Semihost struct {
+	Dir   string
+	files map[uint16]*os.File
+}
+
+// NewSemihost returns semihosting confined to dir
+func NewSemihost(dir string) *Semihost {
+	return &Semihost{
+		Dir:   dir,
+		files: map[uint16]*os.File{},
+	}
+}
+
+// Enable registers the semihosting traps on a machine
+func (s *Semihost) Enable(a *ALU) {
+	a.RegisterTrap(TrapOPEN, s.open)
+	a.RegisterTrap(TrapREAD, s.read)
+	a.RegisterTrap(TrapWRITE, s.write)
+	a.RegisterTrap(TrapCLOSE, s.close)
+	a.RegisterTrap(TrapSEEK, s.seek)
+	a.RegisterTrap(TrapEXIT, trapEXIT)
+}
+
+// Close closes the files the program left open
+func (s *Semihost) Close() error {
+	var err error
+	for fd, f := range s.files {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.files, fd)
+	}
+	return err
+}
+
+// path resolves a file name of the program inside the sandbox. Neither ".." nor
+// symbolic links may lead out of it.
+func (s *Semihost) path(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("empty file name")
+	}
+	p := filepath.Join(s.Dir, filepath.Clean("/"+name))
+
+	root, err := filepath.EvalSymlinks(s.Dir)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(p)
+	if os.IsNotExist(err) {
+		// a file to be created, its directory has to be inside. Creating it through
+		// a dangling symbolic link could put it anywhere.
+		if _, err := os.Lstat(p); err == nil {
+			return "", fmt.Errorf("%s is a dangling symbolic link", name)
+		}
+		resolved, err = filepath.EvalSymlinks(filepath.Dir(p))
+		resolved = filepath.Join(resolved, filepath.Base(p))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the sandbox", name)
+	}
+	return p, nil
+}
+
+func (s *Semihost) open(a *ALU) {
+	flags, ok := map[uint16]int{
+		SemiRead:      os.O_RDONLY,
+		SemiWrite:     os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+		SemiAppend:    os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+		SemiReadWrite: os.O_RDWR,
+	}[a.Reg[1]]
+	if !ok {
+		a.Reg[0] = 0xFFFF
+		return
+	}
+
+	p, err := s.path(readString(a, a.Reg[0]))
+	if err != nil {
+		a.Reg[0] = 0xFFFF
+		return
+	}
+	f, err := os.OpenFile(p, flags, 0644)
+	if err != nil {
+		a.Reg[0] = 0xFFFF
+		return
+	}
+
+	var fd uint16
+	for s.files[fd] != nil {
+		fd++
+	}
+	s.files[fd] = f
+	a.Reg[0] = fd
+}
+
+func (s *Semihost) read(a *ALU) {
+	f := s.files[a.Reg[0]]
+	if f == nil {
+		a.Reg[0] = 0xFFFF
+		return
+	}
+
+	buf := make([]byte, a.Reg[2])
+	n, err := f.Read(buf)
+	if err != nil && err != io.EOF {
+		a.Reg[0] = 0xFFFF
+		return
+	}
+	for i := 0; i < n; i++ {
+		a.Write(a.Reg[1]+uint16(i), uint16(buf[i]))
+	}
+	a.Reg[0] = uint16(n)
+}
+
+func (s *Semihost) write(a *ALU) {
+	f := s.files[a.Reg[0]]
+	if f == nil {
+		a.Reg[0] = 0xFFFF
+		return
+	}
+
+	buf := make([]byte, a.Reg[2])
+	for i := range buf {
+		buf[i] = byte(a.Read(a.Reg[1] + uint16(i)))
+	}
+	n, err := f.Write(buf)
+	if err != nil {
+		a.Reg[0] = 0xFFFF
+		return
+	}
+	a.Reg[0] = uint16(n)
+}
+
+func (s *Semihost) close(a *ALU) {
+	f := s.files[a.Reg[0]]
+	if f == nil {
+		a.Reg[0] = 0xFFFF
+		return
+	}
+
+	delete(s.files, a.Reg[0])
+	if err := f.Close(); err != nil {
+		a.Reg[0] = 0xFFFF
+		return
+	}
+	a.Reg[0] = 0
+}
+
+func (s *Semihost) seek(a *ALU) {
+	f := s.files[a.Reg[0]]
+	if f == nil || a.Reg[2] > SemiSeekEnd {
+		a.Reg[0] = 0xFFFF
+		return
+	}
+
+	pos, err := f.Seek(int64(int16(a.Reg[1])), int(a.Reg[2]))
+	if err != nil {
+		a.Reg[0] = 0xFFFF
+		return
+	}
+	a.Reg[0] = 0
+	a.Reg[1] = uint16(pos)
+}
+
+func trapEXIT(a *ALU) {
+	a.Running = false
+	if a.Reg[0] > MaxExitStatus {
+		a.Err = fmt.Errorf("exit status %d out of range, expected 0 to %d", a.Reg[0], MaxExitStatus)
+		return
+	}
+	a.ExitStatus = int(a.Reg[0])
+}
+
+// readString reads a string of one character per word
+func readString(a *ALU, addr uint16) string {
+	var b strings.Builder
+	for ; a.Read(addr) != 0; addr++ {
+		b.WriteByte(byte(a.Read(addr)))
+	}
+	return b.String()
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// semihostTestProgram copies in.txt to out.txt in reverse order and exits with the number of bytes
+const semihostTestProgram = `
+        .ORIG x3000
+        LEA R0, INNAME
+        AND R1, R1, #0
+        TRAP x40
+        ADD R3, R0, #0
+        LEA R1, BUF
+        LD R2, SIZE
+        TRAP x41
+        ADD R4, R0, #0
+        ADD R0, R3, #0
+        TRAP x43
+        LEA R0, OUTNAME
+        AND R1, R1, #0
+        ADD R1, R1, #1
+        TRAP x40
+        ADD R3, R0, #0
+        LEA R5, BUF
+        ADD R5, R5, R4
+LOOP    ADD R5, R5, #-1
+        ADD R0, R3, #0
+        ADD R1, R5, #0
+        AND R2, R2, #0
+        ADD R2, R2, #1
+        TRAP x42
+        LEA R0, BUF
+        NOT R0, R0
+        ADD R0, R0, #1
+        ADD R0, R0, R5
+        BRp LOOP
+        ADD R0, R3, #0
+        TRAP x43
+        ADD R0, R4, #0
+        TRAP x45
+INNAME  .STRINGZ "in.txt"
+OUTNAME .STRINGZ "sub/../out.txt"
+SIZE    .FILL #16
+BUF     .BLKW #16
+        .END
+`
+
+func TestSemihost(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "semihost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "in.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := assembleALU(t, semihostTestProgram)
+	NewSemihost(dir).Enable(a)
+	for i := 0; a.Running && i < 1000; i++ {
+		a.EmulateInstruction()
+	}
+
+	assert.False(a.Running)
+	assert.Equal(5, a.ExitStatus)
+	assert.Equal(5, a.ExitCode())
+	out, err := ioutil.ReadFile(filepath.Join(dir, "out.txt"))
+	assert.NoError(err)
+	assert.Equal("olleh", string(out))
+}
+
+func TestSemihostExit(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		status uint16
+
+		expectedStatus int
+		expectedErr    string
+	}{
+		{status: 0, expectedStatus: 0},
+		{status: 3, expectedStatus: 3},
+		{status: MaxExitStatus, expectedStatus: MaxExitStatus},
+		{status: 0x100, expectedErr: "exit status 256 out of range, expected 0 to 127"},
+		{status: 0xFFFF, expectedErr: "exit status 65535 out of range, expected 0 to 127"},
+	}
+
+	for _, testData := range tests {
+		a := assembleALU(t, ".ORIG x3000\nLD R0, STATUS\nTRAP x45\nSTATUS .FILL #0\n.END")
+		a.Memory[0x3002] = testData.status
+		NewSemihost(t.TempDir()).Enable(a)
+		for i := 0; a.Running && i < 10; i++ {
+			a.EmulateInstruction()
+		}
+
+		assert.False(a.Running)
+		assert.Equal(testData.expectedStatus, a.ExitStatus, "Status x%04X", testData.status)
+		if testData.expectedErr == "" {
+			assert.NoError(a.Err, "Status x%04X", testData.status)
+			assert.Equal(testData.expectedStatus, a.ExitCode(), "Status x%04X", testData.status)
+		} else {
+			assert.EqualError(a.Err, testData.expectedErr, "Status x%04X", testData.status)
+			assert.Equal(1, a.ExitCode(), "Status x%04X", testData.status)
+		}
+	}
+}
+
+func TestSemihostSeek(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "semihost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "data"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSemihost(dir)
+	defer s.Close()
+	a := NewALU()
+	writeString(a, 0x4000, "data")
+
+	call := func(trap uint8, regs ...uint16) {
+		copy(a.Reg[:], regs)
+		a.traps[trap](a)
+	}
+	s.Enable(a)
+
+	call(TrapOPEN, 0x4000, SemiRead)
+	fd := a.Reg[0]
+	assert.Equal(uint16(0), fd)
+
+	call(TrapSEEK, fd, 0xFFFD, SemiSeekEnd)
+	assert.Equal(uint16(0), a.Reg[0])
+	assert.Equal(uint16(7), a.Reg[1])
+
+	call(TrapREAD, fd, 0x4100, 10)
+	assert.Equal(uint16(3), a.Reg[0])
+	assert.Equal([]uint16{'7', '8', '9'}, a.Memory[0x4100:0x4103])
+
+	call(TrapREAD, fd, 0x4100, 10)
+	assert.Equal(uint16(0), a.Reg[0], "Reading at the end of the file should return 0")
+
+	call(TrapWRITE, fd, 0x4100, 1)
+	assert.Equal(uint16(0xFFFF), a.Reg[0], "Writing a file opened for reading should fail")
+
+	call(TrapCLOSE, fd)
+	assert.Equal(uint16(0), a.Reg[0])
+	call(TrapCLOSE, fd)
+	assert.Equal(uint16(0xFFFF), a.Reg[0], "Closing twice should fail")
+}
+
+func TestSemihostSandbox(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "semihost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sandbox := filepath.Join(dir, "sandbox")
+	assert.NoError(os.Mkdir(sandbox, 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0644))
+	assert.NoError(os.Symlink(dir, filepath.Join(sandbox, "link")))
+	assert.NoError(os.Symlink(filepath.Join(dir, "escaped"), filepath.Join(sandbox, "dangling")))
+
+	s := NewSemihost(sandbox)
+	tests := []struct {
+		name string
+
+		expectedPath string
+		expectedErr  bool
+	}{
+		{name: "file", expectedPath: filepath.Join(sandbox, "file")},
+		{name: "/file", expectedPath: filepath.Join(sandbox, "file")},
+		{name: "../secret", expectedPath: filepath.Join(sandbox, "secret")},
+		{name: "link/secret", expectedErr: true},
+		{name: "link/new", expectedErr: true},
+		{name: "dangling", expectedErr: true},
+		{name: "", expectedErr: true},
+	}
+
+	for _, testData := range tests {
+		p, err := s.path(testData.name)
+		if testData.expectedErr {
+			assert.Error(err, "Should reject %q", testData.name)
+		} else {
+			assert.NoError(err, "Should accept %q", testData.name)
+			assert.Equal(testData.expectedPath, p, "Path of %q", testData.name)
+		}
+	}
+}
+
+// writeString stores a string of one character per word
+func writeString(a *ALU, addr uint16, s string) {
+	for i := 0; i < len(s); i++ {
+		a.Memory[addr+uint16(i)] = uint16(s[i])
+	}
+	a.Memory[addr+uint16(len(s))] = 0
+}
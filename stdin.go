@@ -1,29 +1,202 @@
 package main
 
 import (
+	"errors"
 	"os"
-	"os/exec"
+	"runtime"
+	"sync/atomic"
 )
 
-func disableInputBuffering() {
-    // disable input buffering
-    exec.Command("stty", "-F", "/dev/tty", "cbreak", "min", "1").Run()
-    // do not display entered characters on the screen
-    exec.Command("stty", "-F", "/dev/tty", "-echo").Run()
+// processInput types the console input of the machine on its keyboard until the input fails,
+// which ends the input. It runs in its own goroutine and posts the keys to the CPU.
+// Keys typed on a terminal are pressed as they come and may overflow the typeahead buffer.
+// Redirected input is not lost that way: reading waits while KBDR and the buffer are full.
+func processInput(a *ALU) {
+	f, ok := a.input().(*os.File)
+	interactive := ok && isTerminal(f)
+	capacity := int64(a.TypeAhead) + 1
+	var pressed int64
+
+	var b []byte = make([]byte, 1)
+	for {
+		n, err := a.input().Read(b)
+		if n > 0 {
+			for !interactive && pressed-a.keyboard.taken.Load() >= capacity {
+				<-a.keyboard.room
+			}
+			c := uint16(b[0])
+			a.Post(func() { a.pressKey(c) })
+			pressed++
+		}
+		if err != nil {
+			a.Post(a.endInput)
+			return
+		}
+	}
 }
 
-func processInput(a *ALU) {
-    var b []byte = make([]byte, 1)
-    for {
-        os.Stdin.Read(b)
-		a.Memory[KBSR] = 0x8000
-		a.Memory[KBDR] = uint16(b[0])
-
-		// send to KBSRChan in non-blocking way
-		select {
-			case a.KBSRChan <- struct{}{}:
-			default:
+// EOFPolicy says what happens when the program waits for a key after the end of the input
+type EOFPolicy int
+
+const (
+	EOFError    EOFPolicy = iota // stop the machine with ErrOutOfInput
+	EOFHalt                      // halt the machine as if the program did
+	EOFSentinel                  // type ALU.Sentinel, like x04 (Ctrl-D) or xFFFF, every time
+)
+
+// ErrOutOfInput stops a machine waiting for a key after the end of the input, see EOFError
+var ErrOutOfInput = errors.New("program waits for input after the end of its input")
+
+// DefaultTypeAhead is the number of keys a new machine buffers while KBDR holds an unread one
+const DefaultTypeAhead = 256
+
+// OverflowPolicy says which key is lost when a key is typed while the typeahead buffer is full
+type OverflowPolicy int
+
+const (
+	DropNewest OverflowPolicy = iota // ignore the key pressed
+	DropOldest                       // discard the oldest unread key, the one in KBDR
+)
+
+// keyboard holds the keyboard status and data registers
+type keyboard struct {
+	status uint16
+	data   uint16
+	buffer []uint16 // keys pressed while KBDR holds an unread one, oldest first
+	ended  bool     // no more keys will be pressed
+
+	taken atomic.Int64  // keys read from KBDR, see processInput
+	room  chan struct{} // signalled when a key is read from KBDR
+}
+
+// hasLine reports whether a key that ends a line was typed and not read yet
+func (k *keyboard) hasLine() bool {
+	if k.status&0x8000 != 0 && (k.data == '\n' || k.data == '\r') {
+		return true
+	}
+	for _, c := range k.buffer {
+		if c == '\n' || c == '\r' {
+			return true
+		}
+	}
+	return false
+}
+
+// keyboardDevice returns the bus device for KBSR and KBDR. Reading KBDR clears the ready bit of KBSR
+// unless the typeahead buffer moves the next key into KBDR.
+// While both the ready and the interrupt enable bit are set, the keyboard requests an interrupt.
+// Polling KBSR after the end of the input applies the EOF policy.
+func (a *ALU) keyboardDevice() *Device {
+	k := a.keyboard
+	return &Device{
+		Read: func(addr uint16) uint16 {
+			if addr == KBDR {
+				if k.status&0x8000 != 0 {
+					k.taken.Add(1)
+					select {
+					case k.room <- struct{}{}:
+					default:
+					}
+				}
+				c := k.data
+				k.status &= 0x7FFF
+				if len(k.buffer) > 0 {
+					k.data, k.buffer = k.buffer[0], k.buffer[1:]
+					k.status |= 0x8000
+				}
+				return c
+			}
+			if k.status&0x8000 == 0 {
+				if k.ended {
+					a.inputStarved()
+				} else {
+					// let the input goroutine run while the program polls for a key
+					runtime.Gosched()
+				}
+			}
+			return k.status
+		},
+		Write: func(addr, val uint16) {
+			if addr == KBSR {
+				// only the interrupt enable bit is writable
+				k.status = k.status&0x8000 | val&0x4000
+			}
+		},
+		Peek: func(addr uint16) uint16 {
+			if addr == KBDR {
+				return k.data
+			}
+			return k.status
+		},
+		Interrupt: func() (uint16, uint16, bool) {
+			return IntKeyboard, KeyboardPriority, k.status&0xC000 == 0xC000
+		},
+	}
+}
+
+// pressKey makes a character available in the keyboard registers, or buffers it while
+// KBDR holds an unread one. A full buffer loses a key according to TypeAheadOverflow,
+// which only happens to keys typed on a terminal, see processInput.
+func (a *ALU) pressKey(c uint16) {
+	k := a.keyboard
+	switch {
+	case k.status&0x8000 == 0:
+		k.status |= 0x8000
+		k.data = c
+	case len(k.buffer) < a.TypeAhead:
+		k.buffer = append(k.buffer, c)
+	case a.TypeAheadOverflow == DropOldest && len(k.buffer) > 0:
+		k.data, k.buffer = k.buffer[0], append(k.buffer[1:], c)
+	case a.TypeAheadOverflow == DropOldest:
+		k.data = c
+	}
+}
+
+// endInput tells the keyboard that no more keys will be pressed
+func (a *ALU) endInput() {
+	a.keyboard.ended = true
+}
+
+// inputStarved applies the EOF policy to a program waiting for a key after the end of the input.
+// It reports whether a key was typed; the machine stopped otherwise.
+func (a *ALU) inputStarved() bool {
+	switch a.EOF {
+	case EOFSentinel:
+		a.pressKey(a.Sentinel)
+		return true
+	case EOFHalt:
+		a.Running = false
+	default:
+		a.Running = false
+		a.Err = ErrOutOfInput
+	}
+	return false
+}
+
+// waitKey blocks until a character is available and returns it, clearing the ready bit.
+// It runs posted events while it waits and returns 0 if the machine stopped because the input ended.
+func (a *ALU) waitKey() uint16 {
+	for a.Peek(KBSR)&0x8000 == 0 {
+		if a.keyWait != nil && a.keyWait() {
+			continue
+		}
+		if a.keyboard.ended {
+			if a.inputStarved() {
+				continue
+			}
+			return 0
 		}
-    }
+		(<-a.events)()
+	}
+	return a.Read(KBDR)
 }
 
+// waitsForKey reports whether the next instruction is a trap that would block reading the keyboard
+func (a *ALU) waitsForKey() bool {
+	instr := Decode(a.Memory[a.PCReg])
+	if instr.Op != OpTRAP || a.Peek(KBSR)&0x8000 != 0 {
+		return false
+	}
+	_, gets := a.traps[TrapGETS]
+	return instr.TrapVect == TrapGETC || instr.TrapVect == TrapIN || instr.TrapVect == TrapGETS && gets
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SymbolTable maps labels to addresses and back. Labels are case insensitive like in the assembler.
+// A nil table has no symbols.
+type SymbolTable struct {
+	addrs map[string]uint16 // by upper case label
+	names map[uint16]string // first label of each address
+	order []uint16          // labeled addresses in ascending order
+}
+
+// NewSymbolTable returns an empty symbol table
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		addrs: map[string]uint16{},
+		names: map[uint16]string{},
+	}
+}
+
+// Add defines a label
+func (t *SymbolTable) Add(name string, addr uint16) {
+	t.addrs[strings.ToUpper(name)] = addr
+	if _, ok := t.names[addr]; ok {
+		return
+	}
+	t.names[addr] = name
+	i := sort.Search(len(t.order), func(i int) bool { return t.order[i] >= addr })
+	t.order = append(t.order, 0)
+	copy(t.order[i+1:], t.order[i:])
+	t.order[i] = addr
+}
+
+// Merge adds all labels of another table
+func (t *SymbolTable) Merge(other *SymbolTable) {
+	if other == nil {
+		return
+	}
+	for _, addr := range other.order {
+		t.Add(other.names[addr], addr)
+	}
+	for name, addr := range other.addrs {
+		t.addrs[name] = addr
+	}
+}
+
+// Address returns the address of a label
+func (t *SymbolTable) Address(name string) (uint16, bool) {
+	if t == nil {
+		return 0, false
+	}
+	addr, ok := t.addrs[strings.ToUpper(name)]
+	return addr, ok
+}
+
+// Label returns the label of an address
+func (t *SymbolTable) Label(addr uint16) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	name, ok := t.names[addr]
+	return name, ok
+}
+
+// Target returns the label of an address, or the address if it has none
+func (t *SymbolTable) Target(addr uint16) string {
+	if name, ok := t.Label(addr); ok {
+		return name
+	}
+	return fmt.Sprintf("x%04X", addr)
+}
+
+// Describe returns an address followed by the nearest label up to 255 words before it,
+// like x3004 <LOOP+2>
+func (t *SymbolTable) Describe(addr uint16) string {
+	s := fmt.Sprintf("x%04X", addr)
+	if t == nil {
+		return s
+	}
+
+	i := sort.Search(len(t.order), func(i int) bool { return t.order[i] > addr })
+	if i == 0 || addr-t.order[i-1] > 0xFF {
+		return s
+	}
+	base := t.order[i-1]
+	if base == addr {
+		return fmt.Sprintf("%s <%s>", s, t.names[base])
+	}
+	return fmt.Sprintf("%s <%s+%d>", s, t.names[base], addr-base)
+}
+
+// Resolve evaluates an address expression: a number, a label, or a label plus or minus a number
+func (t *SymbolTable) Resolve(expr string) (uint16, bool) {
+	if expr == "" {
+		return 0, false
+	}
+	if addr, ok := t.Address(expr); ok {
+		return addr, true
+	}
+	if i := strings.LastIndexAny(expr, "+-"); i > 0 {
+		if addr, ok := t.Address(expr[:i]); ok && i+1 < len(expr) {
+			offset, ok := parseNumber(expr[i+1:])
+			if expr[i] == '-' {
+				offset = -offset
+			}
+			return addr + uint16(offset), ok
+		}
+	}
+
+	v, ok := parseNumber(expr)
+	if !ok || v < -0x8000 || v > 0xFFFF {
+		return 0, false
+	}
+	return uint16(v), true
+}
+
+// ParseSymbols reads a symbol file in the format of the standard assembler:
+//
+//	// Symbol table
+//	// Scope level 0:
+//	//	Symbol Name       Page Address
+//	//	----------------  ------------
+//	//	LOOP              3002
+//
+// Lines without a label and a hexadecimal address are ignored.
+func ParseSymbols(r io.Reader) (*SymbolTable, error) {
+	t := NewSymbolTable()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "//"))
+		if len(fields) != 2 {
+			continue
+		}
+		addr, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(fields[1], "x"), "0x"), 16, 16)
+		if err != nil {
+			continue
+		}
+		t.Add(fields[0], uint16(addr))
+	}
+	return t, scanner.Err()
+}
+
+// WriteSymbols writes a symbol file in the format read by ParseSymbols
+func (t *SymbolTable) WriteSymbols(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "// Symbol table\n// Scope level 0:\n//\tSymbol Name       Page Address\n//\t----------------  ------------\n")
+	if t != nil {
+		var names []string
+		for name := range t.addrs {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			a, b := t.addrs[names[i]], t.addrs[names[j]]
+			return a < b || a == b && names[i] < names[j]
+		})
+		for _, name := range names {
+			fmt.Fprintf(bw, "//\t%-16s  %04X\n", name, t.addrs[name])
+		}
+	}
+	return bw.Flush()
+}
+
+// symbolsPath returns the path of the symbol file belonging to an object file
+func symbolsPath(objPath string) string {
+	return strings.TrimSuffix(objPath, filepath.Ext(objPath)) + ".sym"
+}
+
+// LoadSymbols reads the symbol files next to object files, skipping those without one
+func LoadSymbols(objPaths ...string) (*SymbolTable, error) {
+	t := NewSymbolTable()
+	for _, path := range objPaths {
+		f, err := os.Open(symbolsPath(path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		syms, err := ParseSymbols(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", symbolsPath(path), err)
+		}
+		t.Merge(syms)
+	}
+	return t, nil
+}
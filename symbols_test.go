@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const symbolsTestFile = `// Symbol table
+// Scope level 0:
+//	Symbol Name       Page Address
+//	----------------  ------------
+//	START             3000
+//	Loop              3002
+//	MSG               3010
+`
+
+func TestParseSymbols(t *testing.T) {
+	assert := assert.New(t)
+
+	syms, err := ParseSymbols(strings.NewReader(symbolsTestFile))
+	if !assert.NoError(err) {
+		return
+	}
+
+	addr, ok := syms.Address("LOOP")
+	assert.True(ok)
+	assert.Equal(uint16(0x3002), addr)
+	label, ok := syms.Label(0x3002)
+	assert.True(ok)
+	assert.Equal("Loop", label)
+
+	tests := []struct {
+		addr     uint16
+		expected string
+	}{
+		{addr: 0x2FFF, expected: "x2FFF"},
+		{addr: 0x3000, expected: "x3000 <START>"},
+		{addr: 0x3001, expected: "x3001 <START+1>"},
+		{addr: 0x3005, expected: "x3005 <Loop+3>"},
+		{addr: 0x3110, expected: "x3110"},
+	}
+	for _, testData := range tests {
+		assert.Equal(testData.expected, syms.Describe(testData.addr))
+	}
+
+	resolved := map[string]uint16{"loop": 0x3002, "LOOP+2": 0x3004, "MSG-x10": 0x3000, "x4000": 0x4000, "#-1": 0xFFFF}
+	for expr, expected := range resolved {
+		addr, ok := syms.Resolve(expr)
+		assert.True(ok, "Should resolve %s", expr)
+		assert.Equal(expected, addr, "Should resolve %s", expr)
+	}
+	for _, expr := range []string{"", "NOPE", "LOOP+", "x10000"} {
+		_, ok := syms.Resolve(expr)
+		assert.False(ok, "Should not resolve %q", expr)
+	}
+
+	var nilTable *SymbolTable
+	assert.Equal("x3000", nilTable.Describe(0x3000))
+	assert.Equal("BRnzp x3000", DisassembleSym(0x0FFF, 0x3000, nilTable))
+}
+
+func TestWriteSym(t *testing.T) {
+	assert := assert.New(t)
+
+	prog, err := Assemble("a.asm", []byte(".ORIG x3000\nSTART AND R0, R0, #0\nLOOP BR LOOP\nHALT\n.END\n"))
+	if !assert.NoError(err) {
+		return
+	}
+
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	assert.NoError(prog.WriteSym(&buf))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "a.sym"), buf.Bytes(), 0644))
+
+	syms, err := LoadSymbols(filepath.Join(dir, "a.obj"), filepath.Join(dir, "missing.obj"))
+	if !assert.NoError(err) {
+		return
+	}
+
+	var memory [65536]uint16
+	copy(memory[prog.Origin:], prog.Words)
+	var out bytes.Buffer
+	assert.NoError(DisassembleRangeSym(&out, &memory, syms, 0x3000, 0x3002))
+	assert.Equal("START:\nx3000  x5020  AND R0, R0, #0\nLOOP:\nx3001  x0FFF  BRnzp LOOP\nx3002  xF025  HALT\n", out.String())
+}
+
+func TestSymbolsInReports(t *testing.T) {
+	assert := assert.New(t)
+
+	a := assembleALU(t, ".ORIG x3000\nSTART AND R0, R0, #0\nBR BAD\nBAD .FILL xD000\n.END\n")
+	syms, err := ParseSymbols(strings.NewReader("START 3000\nBAD 3002\n"))
+	assert.NoError(err)
+	a.Symbols = syms
+	var trace bytes.Buffer
+	a.Trace = &trace
+
+	for a.Running {
+		a.EmulateInstruction()
+	}
+
+	assert.Equal("x3000 <START>  x5020  AND R0, R0, #0\nx3001 <START+1>  x0E00  BRnzp BAD\nx3002 <BAD>  xD000  .FILL xD000\n", trace.String())
+	assert.EqualError(a.Err, "illegal opcode at x3002 <BAD> (.FILL xD000): no handler installed at x0101")
+
+	d := NewDebugger(assembleALU(t, debuggerTestProgram))
+	d.ALU.Symbols, _ = ParseSymbols(strings.NewReader("INC 3004\n"))
+	var out bytes.Buffer
+	RunDebugCLI(d, strings.NewReader("b INC\nc\nx INC+1\n"), &out)
+	assert.Contains(out.String(), "breakpoint at x3004 <INC>\nx3004 <INC>  x1021  ADD R0, R0, #1\n")
+	assert.Contains(out.String(), "x3005  x1020  ADD R0, R0, #0\n")
+}
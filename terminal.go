@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// withRawTerminal runs f with the terminal on standard input in raw mode, see makeRaw.
+// The terminal is restored when f returns or panics, and before exiting on SIGINT or SIGTERM.
+func withRawTerminal(f func() error) error {
+	restore, err := makeRaw(os.Stdin)
+	if err != nil {
+		return err
+	}
+	var once sync.Once
+	restoreOnce := func() { once.Do(restore) }
+	defer restoreOnce()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case sig := <-signals:
+			restoreOnce()
+			status := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				status = 128 + int(s)
+			}
+			os.Exit(status)
+		case <-done:
+		}
+	}()
+
+	return f()
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package main
+
+import "os"
+
+// makeRaw leaves the terminal alone on systems without termios
+func makeRaw(f *os.File) (func(), error) {
+	return func() {}, nil
+}
+
+// isTerminal treats every file as redirected input on systems without termios
+func isTerminal(f *os.File) bool {
+	return false
+}
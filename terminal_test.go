@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakeRawNotATerminal(t *testing.T) {
+	assert := assert.New(t)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	restore, err := makeRaw(r)
+	assert.NoError(err)
+	assert.NotNil(restore)
+	restore()
+}
+
+func TestWithRawTerminal(t *testing.T) {
+	assert := assert.New(t)
+
+	failed := errors.New("failed")
+	assert.Equal(failed, withRawTerminal(func() error { return failed }))
+	assert.PanicsWithValue("boom", func() {
+		withRawTerminal(func() error { panic("boom") })
+	})
+}
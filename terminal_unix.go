@@ -0,0 +1,38 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// makeRaw switches the terminal f to reading characters as they are typed, without echoing them.
+// Signal keys like Ctrl-C and output processing keep working. It returns a function restoring
+// the original state, which does nothing if f is not a terminal.
+func makeRaw(f *os.File) (func(), error) {
+	fd := int(f.Fd())
+	state, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		// not a terminal
+		return func() {}, nil
+	}
+	saved := *state
+
+	state.Lflag &^= unix.ICANON | unix.ECHO
+	state.Cc[unix.VMIN] = 1
+	state.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlWriteTermios, state); err != nil {
+		return nil, err
+	}
+	return func() {
+		unix.IoctlSetTermios(fd, ioctlWriteTermios, &saved)
+	}, nil
+}
+
+// isTerminal reports whether f is a terminal
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), ioctlReadTermios)
+	return err == nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Traps of the extra bundle
+const (
+	TrapPUTD = 0x30 // output R0 as a signed decimal number
+	TrapGETS = 0x31 // read a line into the buffer at R0 holding R1 words, the length is returned in R1
+)
+
+// TrapHandler implements a trap in Go. It is called with R7 already holding the return
+// address and may use the registers, memory and devices of the machine freely.
+type TrapHandler func(a *ALU)
+
+// TrapBundles are the named sets of trap handlers that can be enabled with EnableTraps
+var TrapBundles = map[string]map[uint8]TrapHandler{
+	"native": nativeTraps,
+	"extra":  extraTraps,
+}
+
+// RegisterTrap makes TRAP vect call h instead of the routine in the trap vector table.
+// A nil handler goes back to the routine.
+func (a *ALU) RegisterTrap(vect uint8, h TrapHandler) {
+	if h == nil {
+		delete(a.traps, vect)
+		return
+	}
+	if a.traps == nil {
+		a.traps = map[uint8]TrapHandler{}
+	}
+	a.traps[vect] = h
+}
+
+// EnableTraps registers the handlers of the named bundles
+func (a *ALU) EnableTraps(bundles ...string) error {
+	for _, name := range bundles {
+		bundle, ok := TrapBundles[name]
+		if !ok {
+			return fmt.Errorf("unknown trap bundle %q, available: %s", name, strings.Join(trapBundleNames(), ", "))
+		}
+		for vect, h := range bundle {
+			a.RegisterTrap(vect, h)
+		}
+	}
+	return nil
+}
+
+func trapBundleNames() []string {
+	var names []string
+	for name := range TrapBundles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// nativeTraps implements the standard service routines in Go, a fast path
+// for the routines of the operating system
+var nativeTraps = map[uint8]TrapHandler{
+	TrapGETC:  (*ALU).trapGETC,
+	TrapOUT:   (*ALU).trapOUT,
+	TrapPUTS:  (*ALU).trapPUTS,
+	TrapIN:    (*ALU).trapIN,
+	TrapPUTSP: (*ALU).trapPUTSP,
+	TrapHALT:  (*ALU).trapHALT,
+}
+
+func (a *ALU) trapGETC() {
+	a.Reg[0] = a.waitKey()
+}
+
+func (a *ALU) trapOUT() {
+	fmt.Fprintf(a.output(), "%c", rune(a.Reg[0]))
+}
+
+func (a *ALU) trapPUTS() {
+	for i := a.Reg[0]; a.Read(i) != 0; i++ {
+		fmt.Fprintf(a.output(), "%c", rune(a.Read(i)))
+	}
+}
+
+func (a *ALU) trapIN() {
+	fmt.Fprint(a.output(), "Enter a character: ")
+	a.Reg[0] = a.waitKey()
+	if a.Running {
+		fmt.Fprintf(a.output(), "%c", rune(a.Reg[0]))
+	}
+}
+
+func (a *ALU) trapPUTSP() {
+	for i := a.Reg[0]; a.Read(i) != 0; i++ {
+		r1 := rune(a.Read(i) & 0xFF)
+		fmt.Fprintf(a.output(), "%c", r1)
+		r2 := rune(a.Read(i) >> 8)
+		if r2 != 0 {
+			fmt.Fprintf(a.output(), "%c", r2)
+		}
+	}
+}
+
+func (a *ALU) trapHALT() {
+	a.Running = false
+}
+
+// extraTraps are system calls beyond the standard ones
+var extraTraps = map[uint8]TrapHandler{
+	TrapPUTD: (*ALU).trapPUTD,
+	TrapGETS: (*ALU).trapGETS,
+}
+
+func (a *ALU) trapPUTD() {
+	fmt.Fprintf(a.output(), "%d", int16(a.Reg[0]))
+}
+
+// trapGETS echoes the characters typed up to a newline, which is not stored. Characters
+// beyond the size of the buffer are dropped and backspace removes the last character.
+func (a *ALU) trapGETS() {
+	var n uint16
+	for {
+		c := a.waitKey()
+		if !a.Running {
+			return
+		}
+		switch {
+		case c == '\n' || c == '\r':
+			fmt.Fprintln(a.output())
+			if a.Reg[1] > 0 {
+				a.Write(a.Reg[0]+n, 0)
+			}
+			a.Reg[1] = n
+			return
+		case c == '\b' || c == 0x7F:
+			if n > 0 {
+				n--
+				fmt.Fprint(a.output(), "\b \b")
+			}
+		case n+1 < a.Reg[1]:
+			a.Write(a.Reg[0]+n, c)
+			n++
+			fmt.Fprintf(a.output(), "%c", rune(c))
+		}
+	}
+}
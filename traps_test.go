@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrapVectorTable(t *testing.T) {
+	assert := assert.New(t)
+
+	a := NewALU()
+	a.Memory[0x0040] = 0x4000
+	a.Memory[PCStart] = 0xF040 // TRAP x40
+
+	a.EmulateInstruction()
+
+	assert.Equal(uint16(0x4000), a.PCReg)
+	assert.Equal(uint16(PCStart+1), a.Reg[7])
+}
+
+const trapsTestProgram = `
+        .ORIG x3000
+        GETC
+        OUT
+        LEA R0, WORDS
+        PUTS
+        LEA R0, BYTES
+        PUTSP
+        IN
+        ADD R2, R0, #0
+        HALT
+WORDS   .STRINGZ " hello"
+BYTES   .FILL x6162
+        .FILL x0063
+        .FILL x0000
+        .END
+`
+
+func TestTraps(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, native := range []bool{false, true} {
+		a := assembleALU(t, trapsTestProgram)
+		if native {
+			assert.NoError(a.EnableTraps("native"))
+		}
+		var out bytes.Buffer
+		a.Out = &out
+
+		input := []byte("xy")
+		for i := 0; a.Running && i < 10000; i++ {
+			if len(input) > 0 && a.Peek(KBSR)&0x8000 == 0 {
+				a.pressKey(uint16(input[0]))
+				input = input[1:]
+			}
+			a.EmulateInstruction()
+		}
+
+		expected := "x hellobacEnter a character: y"
+		assert.False(a.Running, "Should halt with native traps %v", native)
+		assert.Equal(expected, out.String(), "Output with native traps %v", native)
+		assert.Equal(uint16('y'), a.Reg[2], "IN should return the character with native traps %v", native)
+		assert.Equal(uint16(0x3009), a.Reg[7], "HALT should save the return address with native traps %v", native)
+	}
+}
+
+func TestTrapsFromUserMode(t *testing.T) {
+	assert := assert.New(t)
+
+	a := assembleALU(t, `
+        .ORIG x3000
+        LEA R0, MSG
+        PUTS
+        LD R0, CHAR
+        OUT
+        HALT
+MSG     .STRINGZ "hi"
+CHAR    .FILL x21
+        .END
+`)
+	var out bytes.Buffer
+	a.Out = &out
+	a.SetPSR(0x8002)
+	a.Reg[6] = 0x4000
+
+	a.EmulateInstruction()
+	a.EmulateInstruction()
+	assert.False(a.User, "TRAP should switch to supervisor mode")
+	assert.Equal(uint16(SSPStart-2), a.Reg[6], "TRAP should switch to the supervisor stack")
+	assert.Equal(uint16(0x8001), a.Memory[SSPStart-1], "TRAP should push PSR")
+	assert.Equal(uint16(0x3002), a.Memory[SSPStart-2], "TRAP should push PC")
+
+	for i := 0; a.Running && i < 10000; i++ {
+		if a.PCReg == 0x3004 {
+			assert.True(a.User, "RTI should return to user mode")
+			assert.Equal(uint16(0x4000), a.Reg[6], "RTI should return to the user stack")
+		}
+		a.EmulateInstruction()
+	}
+	assert.NoError(a.Err)
+	assert.Equal("hi!", out.String())
+}
+
+func TestUndefinedTrap(t *testing.T) {
+	assert := assert.New(t)
+
+	a := assembleALU(t, ".ORIG x3000\nTRAP x30\n.END")
+	var out bytes.Buffer
+	a.Out = &out
+
+	for i := 0; a.Running && i < 10000; i++ {
+		a.EmulateInstruction()
+	}
+
+	assert.False(a.Running)
+	assert.Equal("\n--- undefined trap executed ---\n", out.String())
+}
+
+func TestHaltBanner(t *testing.T) {
+	assert := assert.New(t)
+
+	a := assembleALU(t, ".ORIG x3000\nHALT\n.END")
+	var out bytes.Buffer
+	a.Out = &out
+	a.ShowHaltBanner(true)
+
+	for i := 0; a.Running && i < 10000; i++ {
+		a.EmulateInstruction()
+	}
+
+	assert.False(a.Running)
+	assert.Equal("\n--- halting the LC-3 ---\n", out.String())
+}
+
+func TestHaltRegisters(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, native := range []bool{false, true} {
+		a := assembleALU(t, ".ORIG x3000\nAND R1, R1, #0\nADD R1, R1, #5\nADD R2, R1, #1\nHALT\n.END")
+		if native {
+			assert.NoError(a.EnableTraps("native"))
+		}
+		for i := 0; a.Running && i < 10000; i++ {
+			a.EmulateInstruction()
+		}
+
+		assert.False(a.Running)
+		assert.Equal(uint16(5), a.Reg[1], "HALT should preserve R1 with native traps %v", native)
+		assert.Equal(uint16(6), a.Reg[2], "HALT should preserve R2 with native traps %v", native)
+	}
+}
+
+func TestRegisterTrap(t *testing.T) {
+	assert := assert.New(t)
+
+	a := NewALU()
+	a.Memory[0x0050] = 0x4000
+	a.Memory[PCStart] = 0xF050 // TRAP x50
+	a.Memory[PCStart+1] = 0xF050
+	a.Reg[0] = 0x3100
+	a.Memory[0x3100] = 20
+
+	a.RegisterTrap(0x50, func(a *ALU) {
+		a.Reg[0] = a.Read(a.Reg[0]) + 1
+	})
+	a.EmulateInstruction()
+	assert.Equal(uint16(21), a.Reg[0])
+	assert.Equal(uint16(PCStart+1), a.PCReg)
+	assert.Equal(uint16(PCStart+1), a.Reg[7])
+
+	a.RegisterTrap(0x50, nil)
+	a.EmulateInstruction()
+	assert.Equal(uint16(0x4000), a.PCReg, "Removing the handler should go back to the trap vector table")
+
+	assert.EqualError(a.EnableTraps("native", "bogus"), `unknown trap bundle "bogus", available: extra, native`)
+}
+
+func TestExtraTraps(t *testing.T) {
+	assert := assert.New(t)
+
+	d := NewDebugger(assembleALU(t, `
+        .ORIG x3000
+        LEA R0, BUF
+        AND R1, R1, #0
+        ADD R1, R1, #4
+        TRAP x31
+        ADD R2, R1, #0
+        LD R0, NUM
+        TRAP x30
+        HALT
+NUM     .FILL #-1234
+BUF     .BLKW 4
+        .END
+`))
+	a := d.ALU
+	var out bytes.Buffer
+	a.Out = &out
+	assert.NoError(a.EnableTraps("native", "extra"))
+
+	assert.Equal(StopInput, d.Continue())
+	assert.Equal(uint16(0x3003), a.PCReg, "GETS should wait for input")
+
+	d.Input = []byte("abx\bcd\n")
+	assert.Equal(StopHalted, d.Continue())
+	assert.Equal(uint16(3), a.Reg[2])
+	assert.Equal([]uint16{'a', 'b', 'c', 0}, a.Memory[0x3009:0x300D])
+	assert.Equal("abx\b \bc\n-1234", out.String())
+}